@@ -0,0 +1,59 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxAttachments = 10
+	// MaxAttachmentSize and MaxAttachmentsSize are in bytes, after base64
+	// decoding. Providers reject messages much above 25 MB.
+	MaxAttachmentSize           = 10 << 20
+	MaxAttachmentsSize          = 20 << 20
+	MaxAttachmentFilenameLength = 255
+)
+
+// Attachment is a file sent with an email. Requests reference an uploaded
+// file by ID or carry its base64 Content, which the API stores and replaces
+// with an ID, so queued messages never hold file content. Filename
+// overrides the name a referenced file was uploaded with.
+type Attachment struct {
+	ID       string `json:"id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// IsValidAttachmentFilename accepts a bare file name without a path or
+// characters that would break the MIME headers it ends up in.
+func IsValidAttachmentFilename(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		len(name) <= MaxAttachmentFilenameLength &&
+		!strings.ContainsAny(name, "/\\\"\r\n\x00")
+}
+
+func validateAttachments(attachments []Attachment, errs *ValidationErrors) {
+	if len(attachments) > MaxAttachments {
+		errs.add("email.attachments", "must contain at most %d attachments", MaxAttachments)
+		return
+	}
+	for i, attachment := range attachments {
+		field := fmt.Sprintf("email.attachments[%d]", i)
+		switch {
+		case (attachment.ID == "") == (attachment.Content == ""):
+			errs.add(field, "must set exactly one of id or content")
+			continue
+		case attachment.ID != "" && !IsValidUUID(attachment.ID):
+			errs.add(field+".id", "must be a UUID")
+		case attachment.Content != "" && attachment.Filename == "":
+			errs.add(field+".filename", "is required with content")
+			continue
+		case base64.StdEncoding.DecodedLen(len(attachment.Content)) > MaxAttachmentSize+2:
+			errs.add(field+".content", "must be at most %d bytes", MaxAttachmentSize)
+		}
+		if attachment.Filename != "" && !IsValidAttachmentFilename(attachment.Filename) {
+			errs.add(field+".filename", "must be a file name of at most %d characters without a path", MaxAttachmentFilenameLength)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package common
+
+// Category decides whether recipients' opt-outs apply to a notification.
+type Category string
+
+const (
+	// TransactionalCategory is for messages the user needs regardless of
+	// their marketing consent, such as password resets and receipts.
+	TransactionalCategory Category = "transactional"
+	MarketingCategory     Category = "marketing"
+)
+
+// Categories lists every category users can set preferences for.
+var Categories = []Category{TransactionalCategory, MarketingCategory}
+
+func IsValidCategory(c Category) bool {
+	switch c {
+	case "", TransactionalCategory, MarketingCategory:
+		return true
+	default:
+		return false
+	}
+}
+
+// RequiresConsent reports whether opted-out recipients must be skipped.
+// Only notifications explicitly marked transactional bypass consent.
+func (c Category) RequiresConsent() bool {
+	return c != TransactionalCategory
+}
+
+// OrDefault returns the category a notification is treated as; one without a
+// category is marketing.
+func (c Category) OrDefault() Category {
+	if c == "" {
+		return MarketingCategory
+	}
+	return c
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// DedupeKeyFor returns the key that identifies a notification's logical
+// event. A caller-supplied dedupeKey is scoped to the caller's API key;
+// otherwise the key is a hash of the API key, type, recipients and content,
+// so the same message from one caller to the same people is a duplicate.
+func DedupeKeyFor(n Notification) string {
+	hash := sha256.New()
+	write := func(fields ...string) {
+		for _, field := range fields {
+			hash.Write([]byte(field))
+			hash.Write([]byte{0})
+		}
+	}
+
+	if n.DedupeKey != "" {
+		write(n.APIKeyID, n.DedupeKey)
+		return "caller:" + hex.EncodeToString(hash.Sum(nil))
+	}
+
+	recipients := make([]string, len(n.To))
+	for i, recipient := range n.To {
+		recipients[i] = recipient.Key()
+	}
+	sort.Strings(recipients)
+	// Maps marshal with sorted keys, so equal data hashes equally.
+	data, _ := json.Marshal(n.Data)
+	localized, _ := json.Marshal(n.Localized)
+	email, _ := json.Marshal(n.Email)
+
+	write(n.APIKeyID, string(n.Type), strconv.Itoa(len(recipients)))
+	write(recipients...)
+	write(n.From, n.Subject, n.Content, n.TemplateID, strconv.Itoa(n.TemplateVersion), string(data), string(localized), string(email))
+	return "content:" + hex.EncodeToString(hash.Sum(nil))
+}
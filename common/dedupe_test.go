@@ -0,0 +1,86 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDedupeKeyFor(t *testing.T) {
+	base := Notification{
+		ID:       "5b0f4c1e-8f2a-4a8e-9c36-0d6a1c2b7e11",
+		Type:     EmailNotificationType,
+		To:       []Recipient{UserRecipient("563c1e2a-0b3e-4a55-9b0e-0d6a1c2b7e11"), {Email: "vendor@example.com"}},
+		From:     "noreply@example.com",
+		Subject:  "Hello",
+		Content:  "Hi there",
+		APIKeyID: "key-a",
+	}
+	with := func(change func(n *Notification)) Notification {
+		n := base
+		n.To = append([]Recipient(nil), base.To...)
+		change(&n)
+		return n
+	}
+
+	tests := []struct {
+		name  string
+		other Notification
+		same  bool
+	}{
+		{"different ID", with(func(n *Notification) { n.ID = "another" }), true},
+		{"recipients reordered", with(func(n *Notification) { n.To[0], n.To[1] = n.To[1], n.To[0] }), true},
+		{"recipient case", with(func(n *Notification) { n.To[1].Email = "Vendor@Example.com" }), true},
+		{"other API key", with(func(n *Notification) { n.APIKeyID = "key-b" }), false},
+		{"other content", with(func(n *Notification) { n.Content = "Bye" }), false},
+		{"other subject", with(func(n *Notification) { n.Subject = "Hi" }), false},
+		{"other recipients", with(func(n *Notification) { n.To = n.To[:1] }), false},
+		{"other type", with(func(n *Notification) { n.Type = SmsNotificationType }), false},
+		{"other data", with(func(n *Notification) { n.Data = map[string]interface{}{"a": 1} }), false},
+		{"localized", with(func(n *Notification) { n.Localized = map[string]LocalizedContent{"bg": {Content: "Здравей"}} }), false},
+		{"email options", with(func(n *Notification) { n.Email = &EmailOptions{Cc: []string{"a@example.com"}} }), false},
+	}
+	key := DedupeKeyFor(base)
+	if !strings.HasPrefix(key, "content:") {
+		t.Fatalf("DedupeKeyFor() = %q, want a content key", key)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DedupeKeyFor(tt.other) == key; got != tt.same {
+				t.Errorf("same key = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestDedupeKeyForCallerKey(t *testing.T) {
+	a := Notification{Type: EmailNotificationType, Content: "one", APIKeyID: "key-a", DedupeKey: "order-1042"}
+	b := Notification{Type: SmsNotificationType, Content: "two", APIKeyID: "key-a", DedupeKey: "order-1042"}
+	other := Notification{Type: EmailNotificationType, Content: "one", APIKeyID: "key-b", DedupeKey: "order-1042"}
+
+	if !strings.HasPrefix(DedupeKeyFor(a), "caller:") {
+		t.Fatalf("DedupeKeyFor() = %q, want a caller key", DedupeKeyFor(a))
+	}
+	if DedupeKeyFor(a) != DedupeKeyFor(b) {
+		t.Error("the same caller key gave different dedupe keys")
+	}
+	if DedupeKeyFor(a) == DedupeKeyFor(other) {
+		t.Error("caller keys of different API keys collided")
+	}
+}
+
+func TestNewCopyMessage(t *testing.T) {
+	original := Notification{ID: "original", Type: EmailNotificationType, Content: "Hi", APIKeyID: "key-a", DedupeKey: "order-1042"}
+	copied := original
+	copied.ID = "copy"
+
+	message := NewCopyMessage(copied)
+	if message.DedupeKey != "copy:copy" {
+		t.Errorf("DedupeKey = %q, want %q", message.DedupeKey, "copy:copy")
+	}
+	if message.Notification.DedupeKey != "" {
+		t.Errorf("Notification.DedupeKey = %q, want it cleared", message.Notification.DedupeKey)
+	}
+	if message.DedupeKey == NewNotificationMessage(original).DedupeKey {
+		t.Error("copy shares the original's dedupe key")
+	}
+}
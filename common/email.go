@@ -0,0 +1,117 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxFromNameLength    = 200
+	MaxEmailHeaders      = 20
+	MaxEmailHeaderLength = 1000
+)
+
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,75}$`)
+
+// reservedHeaders are set from the notification itself and cannot be
+// overridden through EmailOptions.Headers.
+var reservedHeaders = map[string]bool{
+	"bcc":                       true,
+	"cc":                        true,
+	"content-transfer-encoding": true,
+	"content-type":              true,
+	"date":                      true,
+	"from":                      true,
+	"message-id":                true,
+	"mime-version":              true,
+	"reply-to":                  true,
+	"sender":                    true,
+	"subject":                   true,
+	"to":                        true,
+}
+
+// EmailOptions holds the fields only email notifications use. HTML is an
+// alternative to Content: when Content is empty the plain-text part is
+// generated from the HTML.
+type EmailOptions struct {
+	HTML        string            `json:"html,omitempty"`
+	FromName    string            `json:"fromName,omitempty"`
+	ReplyTo     string            `json:"replyTo,omitempty"`
+	Cc          []string          `json:"cc,omitempty"`
+	Bcc         []string          `json:"bcc,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+}
+
+// validateEmailOptions checks the email options of a notification.
+func validateEmailOptions(n Notification, errs *ValidationErrors) {
+	if n.Email == nil {
+		return
+	}
+	if n.Type != EmailNotificationType {
+		errs.add("email", "is only valid for email notifications")
+		return
+	}
+	options := n.Email
+
+	if options.HTML != "" {
+		if n.TemplateID != "" {
+			errs.add("email.html", "cannot be combined with templateId, templates render to HTML")
+		} else if utf8.RuneCountInString(options.HTML) > MaxEmailContentLength {
+			errs.add("email.html", "must be at most %d characters", MaxEmailContentLength)
+		}
+	}
+
+	if utf8.RuneCountInString(options.FromName) > MaxFromNameLength {
+		errs.add("email.fromName", "must be at most %d characters", MaxFromNameLength)
+	} else if strings.ContainsAny(options.FromName, "\r\n") {
+		errs.add("email.fromName", "must not contain line breaks")
+	}
+
+	if options.ReplyTo != "" && !IsValidEmailAddress(options.ReplyTo) {
+		errs.add("email.replyTo", "must be an email address")
+	}
+
+	if len(n.To)+len(options.Cc)+len(options.Bcc) > MaxRecipients {
+		errs.add("email", "to, cc and bcc must contain at most %d recipients together", MaxRecipients)
+	}
+	for i, address := range options.Cc {
+		if !IsValidEmailAddress(address) {
+			errs.add(fmt.Sprintf("email.cc[%d]", i), "must be an email address")
+		}
+	}
+	for i, address := range options.Bcc {
+		if !IsValidEmailAddress(address) {
+			errs.add(fmt.Sprintf("email.bcc[%d]", i), "must be an email address")
+		}
+	}
+
+	validateAttachments(options.Attachments, errs)
+
+	if len(options.Headers) > MaxEmailHeaders {
+		errs.add("email.headers", "must contain at most %d headers", MaxEmailHeaders)
+		return
+	}
+	names := make([]string, 0, len(options.Headers))
+	for name := range options.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "email.headers." + name
+		value := options.Headers[name]
+		switch {
+		case !headerNamePattern.MatchString(name):
+			errs.add(field, "must be named with letters, digits and dashes")
+		case reservedHeaders[strings.ToLower(name)]:
+			errs.add(field, "is set by the service and cannot be overridden")
+		case len(value) > MaxEmailHeaderLength:
+			errs.add(field, "must be at most %d characters", MaxEmailHeaderLength)
+		case strings.ContainsAny(value, "\r\n"):
+			errs.add(field, "must not contain line breaks")
+		}
+	}
+}
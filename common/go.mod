@@ -1,3 +1,5 @@
 module github.com/pdragnev/notification-system/common
 
 go 1.21.4
+
+require github.com/rabbitmq/amqp091-go v1.9.0
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlHiddenPattern    = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlSpacePattern     = regexp.MustCompile(`\s+`)
+	htmlListItemPattern  = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	htmlParagraphPattern = regexp.MustCompile(`(?i)</(p|div|h[1-6]|ul|ol|table|blockquote)\s*>`)
+	htmlBreakPattern     = regexp.MustCompile(`(?i)<br\s*/?>|</tr\s*>`)
+	htmlTagPattern       = regexp.MustCompile(`<[^>]*>`)
+	blankLinesPattern    = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText returns a plain-text version of an HTML body. Block elements and
+// line breaks become new lines, list items become "- " lines, and scripts,
+// styles and all other markup are dropped.
+func HTMLToText(body string) string {
+	text := htmlHiddenPattern.ReplaceAllString(body, "")
+	text = htmlSpacePattern.ReplaceAllString(text, " ")
+	text = htmlListItemPattern.ReplaceAllString(text, "\n- ")
+	text = htmlParagraphPattern.ReplaceAllString(text, "\n\n")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,28 @@
+package common
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"plain text", "Hello there", "Hello there"},
+		{"inline markup", "<p>Hello <b>there</b></p>", "Hello there"},
+		{"paragraphs", "<p>First</p><p>Second</p>", "First\n\nSecond"},
+		{"line breaks", "One<br>Two<br/>Three", "One\nTwo\nThree"},
+		{"list items", "<ul><li>Apples</li><li>Pears</li></ul>", "- Apples\n- Pears"},
+		{"scripts and styles", "<head><title>x</title></head><style>p{}</style><p>Body</p><script>alert(1)</script>", "Body"},
+		{"entities", "<p>Tom &amp; Jerry &lt;3</p>", "Tom & Jerry <3"},
+		{"collapses whitespace", "<p>  Hello\n\n   world  </p>", "Hello world"},
+		{"blank lines", "<div>A</div><br><br><br><div>B</div>", "A\n\nB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToText(tt.html); got != tt.want {
+				t.Errorf("HTMLToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
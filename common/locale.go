@@ -0,0 +1,42 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// LocalizedContent is a notification's subject and content in one locale.
+// An empty subject falls back to the notification's own subject. HTML is the
+// email HTML body in that locale, like EmailOptions.HTML.
+type LocalizedContent struct {
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// IsValidLocale accepts BCP 47 style tags such as "bg", "bg-BG" or "zh-Hant-TW".
+func IsValidLocale(tag string) bool {
+	return localePattern.MatchString(tag)
+}
+
+// MatchLocale returns the variant to use for a recipient's locale: the exact
+// tag, then each shorter prefix, so "bg-BG" falls back to "bg". It returns
+// "", the default content, when no variant matches. Tags compare
+// case-insensitively and the variant is returned as spelled in variants.
+func MatchLocale(locale string, variants []string) string {
+	for tag := strings.ToLower(locale); tag != ""; {
+		for _, variant := range variants {
+			if strings.ToLower(variant) == tag {
+				return variant
+			}
+		}
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package common
+
+import "testing"
+
+func TestIsValidLocale(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"bg", true},
+		{"bg-BG", true},
+		{"zh-Hant-TW", true},
+		{"fil", true},
+		{"", false},
+		{"b", false},
+		{"bg_BG", false},
+		{"bg-", false},
+		{"bulgarian", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := IsValidLocale(tt.tag); got != tt.valid {
+				t.Errorf("IsValidLocale(%q) = %v, want %v", tt.tag, got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	variants := []string{"bg", "en-GB", "zh-Hant"}
+	tests := []struct {
+		name   string
+		locale string
+		want   string
+	}{
+		{"exact", "bg", "bg"},
+		{"region falls back to the language", "bg-BG", "bg"},
+		{"several subtags", "zh-Hant-TW", "zh-Hant"},
+		{"case-insensitive, spelled as the variant", "EN-gb", "en-GB"},
+		{"no variant for the language", "fr-FR", ""},
+		{"language does not match a region", "en", ""},
+		{"empty locale", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchLocale(tt.locale, variants); got != tt.want {
+				t.Errorf("MatchLocale(%q) = %q, want %q", tt.locale, got, tt.want)
+			}
+		})
+	}
+}
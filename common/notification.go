@@ -1,7 +1,12 @@
 package common
 
+import (
+	"strings"
+	"time"
+)
+
 type NotificationService interface {
-	SendNotification(notification Notification) error
+	SendNotification(notification Notification) (string, error)
 }
 
 type NotificationType string
@@ -12,6 +17,9 @@ const (
 	// more types
 )
 
+// NotificationTypes lists every channel a notification can be sent on.
+var NotificationTypes = []NotificationType{EmailNotificationType, SmsNotificationType}
+
 func IsValidType(t NotificationType) bool {
 	switch t {
 	case EmailNotificationType, SmsNotificationType:
@@ -22,14 +30,60 @@ func IsValidType(t NotificationType) bool {
 }
 
 type Notification struct {
-	Type    NotificationType `json:"type"`
-	To      []string         `json:"to"`
-	From    string           `json:"from"`
-	Subject string           `json:"subject"`
-	Content string           `json:"content"`
+	ID              string                      `json:"id"`
+	Type            NotificationType            `json:"type"`
+	To              []Recipient                 `json:"to"`
+	From            string                      `json:"from"`
+	Subject         string                      `json:"subject"`
+	Content         string                      `json:"content"`
+	Localized       map[string]LocalizedContent `json:"localized,omitempty"`
+	Email           *EmailOptions               `json:"email,omitempty"`
+	SendAt          *time.Time                  `json:"sendAt,omitempty"`
+	Priority        Priority                    `json:"priority,omitempty"`
+	Category        Category                    `json:"category,omitempty"`
+	TemplateID      string                      `json:"templateId,omitempty"`
+	TemplateVersion int                         `json:"templateVersion,omitempty"`
+	Data            map[string]interface{}      `json:"data,omitempty"`
+	CallbackURL     string                      `json:"callbackUrl,omitempty"`
+	APIKeyID        string                      `json:"apiKeyId,omitempty"`
+	DigestKey       string                      `json:"digestKey,omitempty"`
+	DedupeKey       string                      `json:"dedupeKey,omitempty"`
+	// DigestID is set on the notification that sends a digest, and on its
+	// copies, so its outcome can be passed on to the buffered notifications.
+	DigestID string `json:"digestId,omitempty"`
+}
+
+// htmlBody returns the email HTML body, or "" when there is none.
+func (n Notification) htmlBody() string {
+	if n.Email == nil {
+		return ""
+	}
+	return strings.TrimSpace(n.Email.HTML)
+}
+
+// IsScheduled reports whether the notification must be held until SendAt.
+func (n Notification) IsScheduled() bool {
+	return n.SendAt != nil && n.SendAt.After(time.Now())
 }
 
 type NotificationMessage struct {
 	Notification Notification `json:"notification"`
 	RetryCount   int          `json:"retryCount"`
+	// DedupeKey identifies the logical event; the worker drops messages whose
+	// key it delivered recently.
+	DedupeKey string `json:"dedupeKey,omitempty"`
+}
+
+// NewNotificationMessage wraps a notification for the queue.
+func NewNotificationMessage(n Notification) NotificationMessage {
+	return NotificationMessage{Notification: n, DedupeKey: DedupeKeyFor(n)}
+}
+
+// NewCopyMessage wraps a notification the worker derived from another one,
+// such as a rerouted or deferred copy or a digest. The original holds the
+// event's dedupe key, so the copy is keyed by its own ID: only a redelivery
+// of the copy itself is a duplicate.
+func NewCopyMessage(n Notification) NotificationMessage {
+	n.DedupeKey = ""
+	return NotificationMessage{Notification: n, DedupeKey: "copy:" + n.ID}
 }
@@ -0,0 +1,32 @@
+package common
+
+type Priority string
+
+const (
+	LowPriority      Priority = "low"
+	NormalPriority   Priority = "normal"
+	HighPriority     Priority = "high"
+	CriticalPriority Priority = "critical"
+)
+
+// Priorities lists every lane from the most to the least urgent.
+var Priorities = []Priority{CriticalPriority, HighPriority, NormalPriority, LowPriority}
+
+// IsValidPriority accepts the known priorities and the empty value, which means normal.
+func IsValidPriority(p Priority) bool {
+	switch p {
+	case "", LowPriority, NormalPriority, HighPriority, CriticalPriority:
+		return true
+	default:
+		return false
+	}
+}
+
+// QueueNameForPriority returns the queue of the given lane. Normal priority
+// keeps the base queue name so existing producers and consumers keep working.
+func QueueNameForPriority(baseQueue string, p Priority) string {
+	if p == "" || p == NormalPriority {
+		return baseQueue
+	}
+	return baseQueue + "." + string(p)
+}
@@ -0,0 +1,68 @@
+package common
+
+import "time"
+
+const timeOfDayLayout = "15:04"
+
+// QuietHours is a daily window in the user's time zone during which
+// non-critical notifications are held back. Start and End are "HH:MM"; a
+// window whose end is before its start runs past midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// IsValidTimeZone accepts IANA time zone names such as "Europe/Sofia".
+func IsValidTimeZone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+func IsValidTimeOfDay(s string) bool {
+	_, err := time.Parse(timeOfDayLayout, s)
+	return err == nil
+}
+
+// Until reports whether now falls within the quiet hours in the given time
+// zone and, if so, when they end. An unknown time zone is treated as UTC.
+func (q QuietHours) Until(now time.Time, timeZone string) (time.Time, bool) {
+	start, startErr := time.Parse(timeOfDayLayout, q.Start)
+	end, endErr := time.Parse(timeOfDayLayout, q.End)
+	if startErr != nil || endErr != nil || q.Start == q.End {
+		return time.Time{}, false
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	var quiet bool
+	if startMinute < endMinute {
+		quiet = minute >= startMinute && minute < endMinute
+	} else {
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	year, month, day := local.Date()
+	until := time.Date(year, month, day, end.Hour(), end.Minute(), 0, 0, location)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	// An end in the hour skipped when the clocks go forward is reached when
+	// they jump, not at the time.Date normalization an hour later.
+	if until.Hour() != end.Hour() || until.Minute() != end.Minute() {
+		until, _ = until.ZoneBounds()
+	}
+	return until, true
+}
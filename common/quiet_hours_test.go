@@ -0,0 +1,54 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Skipf("time zone data is not available: %v", err)
+	}
+	at := func(location *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, location)
+	}
+
+	tests := []struct {
+		name     string
+		quiet    QuietHours
+		now      time.Time
+		timeZone string
+		want     time.Time
+		ok       bool
+	}{
+		{"before a daytime window", QuietHours{"13:00", "15:00"}, at(time.UTC, 6, 10, 12, 59), "UTC", time.Time{}, false},
+		{"in a daytime window", QuietHours{"13:00", "15:00"}, at(time.UTC, 6, 10, 13, 0), "UTC", at(time.UTC, 6, 10, 15, 0), true},
+		{"at the end of a window", QuietHours{"13:00", "15:00"}, at(time.UTC, 6, 10, 15, 0), "UTC", time.Time{}, false},
+		{"past midnight, before it", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 10, 23, 30), "UTC", at(time.UTC, 6, 11, 7, 0), true},
+		{"past midnight, after it", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 11, 3, 0), "UTC", at(time.UTC, 6, 11, 7, 0), true},
+		{"past midnight, outside", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 11, 12, 0), "UTC", time.Time{}, false},
+		{"past the end of the month", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 30, 22, 0), "UTC", at(time.UTC, 7, 1, 7, 0), true},
+		{"user's time zone", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 10, 20, 0), "Europe/Sofia", at(sofia, 6, 11, 7, 0), true},
+		{"unknown time zone is UTC", QuietHours{"22:00", "07:00"}, at(time.UTC, 6, 10, 23, 0), "Mars/Olympus", at(time.UTC, 6, 11, 7, 0), true},
+		// Clocks in Sofia go from 03:00 to 04:00 on 29 March 2026 and from
+		// 04:00 back to 03:00 on 25 October. A window ends at its local end
+		// time, or when the clocks jump past it.
+		{"night the clocks go forward", QuietHours{"22:00", "07:00"}, at(sofia, 3, 28, 23, 0), "Europe/Sofia", at(sofia, 3, 29, 7, 0), true},
+		{"night the clocks go back", QuietHours{"22:00", "07:00"}, at(sofia, 10, 24, 23, 0), "Europe/Sofia", at(sofia, 10, 25, 7, 0), true},
+		{"end skipped by the clocks", QuietHours{"22:00", "03:30"}, at(sofia, 3, 28, 23, 0), "Europe/Sofia", at(sofia, 3, 29, 4, 0), true},
+		{"start equals end", QuietHours{"22:00", "22:00"}, at(time.UTC, 6, 10, 22, 0), "UTC", time.Time{}, false},
+		{"invalid times", QuietHours{"late", "07:00"}, at(time.UTC, 6, 10, 23, 0), "UTC", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, ok := tt.quiet.Until(tt.now, tt.timeZone)
+			if ok != tt.ok {
+				t.Fatalf("quiet = %v, want %v", ok, tt.ok)
+			}
+			if !until.Equal(tt.want) {
+				t.Errorf("until = %v, want %v", until, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type ConnectionState string
+
+const (
+	StateConnected    ConnectionState = "connected"
+	StateBlocked      ConnectionState = "blocked"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var (
+	ErrNotConnected     = errors.New("not connected to RabbitMQ")
+	ErrConnectionClosed = errors.New("RabbitMQ connection manager is closed")
+)
+
+// ConnectionManager owns the AMQP connection and redials it with exponential
+// backoff whenever the broker closes it. OnConnect runs after every redial,
+// before the connection is handed out again.
+type ConnectionManager struct {
+	url       string
+	onConnect func(*amqp091.Connection) error
+
+	mu          sync.RWMutex
+	conn        *amqp091.Connection
+	state       ConnectionState
+	blockReason string
+	done        chan struct{}
+}
+
+// NewConnectionManager dials url once and fails if the broker is unreachable
+// at startup; later disconnects are retried in the background.
+func NewConnectionManager(url string, onConnect func(*amqp091.Connection) error) (*ConnectionManager, error) {
+	conn, err := amqp091.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+	m := &ConnectionManager{
+		url:       url,
+		onConnect: onConnect,
+		done:      make(chan struct{}),
+	}
+	m.setConnection(conn)
+	return m, nil
+}
+
+// Connection returns the current connection, ErrNotConnected while a
+// reconnect is in progress, or ErrConnectionClosed after Close.
+func (m *ConnectionManager) Connection() (*amqp091.Connection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state == StateClosed {
+		return nil, ErrConnectionClosed
+	}
+	if m.conn == nil || m.conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return m.conn, nil
+}
+
+// State returns the connection state and, when the broker has blocked
+// publishing, the reason it gave.
+func (m *ConnectionManager) State() (ConnectionState, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state, m.blockReason
+}
+
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	if m.state == StateClosed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.state = StateClosed
+	conn := m.conn
+	close(m.done)
+	m.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (m *ConnectionManager) setConnection(conn *amqp091.Connection) {
+	closed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+	blocked := conn.NotifyBlocked(make(chan amqp091.Blocking, 1))
+
+	m.mu.Lock()
+	if m.state == StateClosed {
+		m.mu.Unlock()
+		conn.Close()
+		return
+	}
+	m.conn = conn
+	m.state = StateConnected
+	m.blockReason = ""
+	m.mu.Unlock()
+
+	go m.watch(closed, blocked)
+}
+
+func (m *ConnectionManager) watch(closed chan *amqp091.Error, blocked chan amqp091.Blocking) {
+	for {
+		select {
+		case <-m.done:
+			return
+		case b, ok := <-blocked:
+			if !ok {
+				// Closed together with the connection; the close case
+				// below takes over.
+				blocked = nil
+				continue
+			}
+			m.mu.Lock()
+			if b.Active {
+				log.Printf("RabbitMQ blocked publishing: %s", b.Reason)
+				m.state, m.blockReason = StateBlocked, b.Reason
+			} else {
+				log.Printf("RabbitMQ unblocked publishing")
+				m.state, m.blockReason = StateConnected, ""
+			}
+			m.mu.Unlock()
+		case amqpErr := <-closed:
+			log.Printf("RabbitMQ connection closed: %v", amqpErr)
+			m.reconnect()
+			return
+		}
+	}
+}
+
+func (m *ConnectionManager) reconnect() {
+	m.mu.Lock()
+	if m.state == StateClosed {
+		m.mu.Unlock()
+		return
+	}
+	m.state = StateReconnecting
+	m.mu.Unlock()
+
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-m.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := amqp091.Dial(m.url)
+		if err == nil && m.onConnect != nil {
+			if err = m.onConnect(conn); err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			log.Printf("Reconnected to RabbitMQ after %d attempt(s)", attempt)
+			m.setConnection(conn)
+			return
+		}
+
+		log.Printf("RabbitMQ reconnect attempt %d failed: %v", attempt, err)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
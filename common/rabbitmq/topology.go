@@ -0,0 +1,76 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// DeclareTopology declares the dead letter exchange, the DLQ and one queue
+// per priority lane on conn. The API and the worker both declare it, so
+// either can bring the queues back after the broker lost them.
+func DeclareTopology(conn *amqp091.Connection, dlxName string, dlqName string, primaryQueueName string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// Ensure DLX exists
+	err = ch.ExchangeDeclare(
+		dlxName,
+		"fanout",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare DLX: %v", err)
+	}
+
+	_, err = ch.QueueDeclare(
+		dlqName,
+		true,
+		false,
+		false,
+		false,
+		amqp091.Table{"x-dead-letter-exchange": dlxName},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare DLQ: %v", err)
+	}
+
+	// Bind DLQ to DLX
+	err = ch.QueueBind(
+		dlqName,
+		"", // routing key
+		dlxName,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bind DLQ to DLX: %v", err)
+	}
+
+	// Create or ensure one primary queue per priority lane exists with DLX configuration
+	for _, priority := range common.Priorities {
+		_, err = ch.QueueDeclare(
+			common.QueueNameForPriority(primaryQueueName, priority),
+			true,  // Durable
+			false, // Delete when unused
+			false, // Exclusive
+			false, // No-wait
+			amqp091.Table{
+				"x-dead-letter-exchange": dlxName, // DLX
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare %s priority queue with DLX: %v", priority, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Recipient is either a user to look up or a literal address. Exactly one of
+// UserID, Email and Phone is set. In JSON a plain string is a user ID, so
+// requests and queued messages that use `"to": ["<user id>"]` keep working.
+// Locale overrides the locale stored for a user.
+type Recipient struct {
+	UserID string `json:"userId,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Phone  string `json:"phone,omitempty"`
+	Locale string `json:"locale,omitempty"`
+}
+
+// recipientFields has the same fields as Recipient without its JSON methods.
+type recipientFields Recipient
+
+func UserRecipient(userID string) Recipient {
+	return Recipient{UserID: userID}
+}
+
+// MarshalJSON writes user recipients as plain strings, so workers that only
+// know user IDs can still read the message.
+func (r Recipient) MarshalJSON() ([]byte, error) {
+	if r.UserID != "" && r.Email == "" && r.Phone == "" && r.Locale == "" {
+		return json.Marshal(r.UserID)
+	}
+	return json.Marshal(recipientFields(r))
+}
+
+func (r *Recipient) UnmarshalJSON(data []byte) error {
+	var userID string
+	if err := json.Unmarshal(data, &userID); err == nil {
+		*r = Recipient{UserID: userID}
+		return nil
+	}
+
+	var fields recipientFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("recipient must be a user ID or an object with userId, email or phone: %w", err)
+	}
+	*r = Recipient(fields)
+	return nil
+}
+
+// Key identifies a recipient, for duplicate detection and for collecting a
+// recipient's digest.
+func (r Recipient) Key() string {
+	switch {
+	case r.UserID != "":
+		return "user:" + strings.ToLower(r.UserID)
+	case r.Email != "":
+		return "email:" + strings.ToLower(r.Email)
+	default:
+		return "phone:" + r.Phone
+	}
+}
+
+// SplitRecipients returns the user IDs to look up and the literal email
+// addresses and phone numbers, each in request order.
+func SplitRecipients(recipients []Recipient) (userIDs []string, emails []string, phones []string) {
+	for _, r := range recipients {
+		switch {
+		case r.UserID != "":
+			userIDs = append(userIDs, r.UserID)
+		case r.Email != "":
+			emails = append(emails, r.Email)
+		case r.Phone != "":
+			phones = append(phones, r.Phone)
+		}
+	}
+	return userIDs, emails, phones
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRecipientJSON(t *testing.T) {
+	const userID = "563cfe60-6ed7-49ac-ba33-f05758831980"
+	tests := []struct {
+		name      string
+		recipient Recipient
+		json      string
+	}{
+		{"user as a string", UserRecipient(userID), `"` + userID + `"`},
+		{"user with a locale", Recipient{UserID: userID, Locale: "bg"}, `{"userId":"` + userID + `","locale":"bg"}`},
+		{"email", Recipient{Email: "vendor@example.com"}, `{"email":"vendor@example.com"}`},
+		{"phone with a locale", Recipient{Phone: "+359890123456", Locale: "bg-BG"}, `{"phone":"+359890123456","locale":"bg-BG"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.recipient)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("marshal = %s, want %s", data, tt.json)
+			}
+			var decoded Recipient
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if decoded != tt.recipient {
+				t.Errorf("unmarshal = %+v, want %+v", decoded, tt.recipient)
+			}
+		})
+	}
+}
+
+func TestRecipientUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []Recipient
+		err  bool
+	}{
+		{"legacy user IDs", `["a", "b"]`, []Recipient{UserRecipient("a"), UserRecipient("b")}, false},
+		{"mixed", `["a", {"email": "vendor@example.com"}]`, []Recipient{UserRecipient("a"), {Email: "vendor@example.com"}}, false},
+		{"object with a user ID", `[{"userId": "a"}]`, []Recipient{UserRecipient("a")}, false},
+		{"number", `[42]`, nil, true},
+		{"list", `[["a"]]`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Recipient
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("recipient %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecipientKey(t *testing.T) {
+	tests := []struct {
+		recipient Recipient
+		key       string
+	}{
+		{UserRecipient("563CFE60-6ED7-49AC-BA33-F05758831980"), "user:563cfe60-6ed7-49ac-ba33-f05758831980"},
+		{Recipient{Email: "Vendor@Example.com"}, "email:vendor@example.com"},
+		{Recipient{Phone: "+359890123456"}, "phone:+359890123456"},
+		{Recipient{Email: "vendor@example.com", Locale: "bg"}, "email:vendor@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := tt.recipient.Key(); got != tt.key {
+				t.Errorf("Key() = %q, want %q", got, tt.key)
+			}
+		})
+	}
+}
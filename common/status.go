@@ -0,0 +1,30 @@
+package common
+
+type NotificationStatus string
+
+const (
+	ScheduledStatus    NotificationStatus = "scheduled"
+	CancelledStatus    NotificationStatus = "cancelled"
+	QueuedStatus       NotificationStatus = "queued"
+	DeferredStatus     NotificationStatus = "deferred"
+	ProcessingStatus   NotificationStatus = "processing"
+	RetryingStatus     NotificationStatus = "retrying"
+	DeliveredStatus    NotificationStatus = "delivered"
+	DigestedStatus     NotificationStatus = "digested"
+	SkippedStatus      NotificationStatus = "skipped"
+	FailedStatus       NotificationStatus = "failed"
+	DeadLetteredStatus NotificationStatus = "dead-lettered"
+)
+
+// FinalStatuses are the statuses a notification ends in.
+var FinalStatuses = []NotificationStatus{CancelledStatus, DeliveredStatus, SkippedStatus, FailedStatus, DeadLetteredStatus}
+
+// IsFinal reports whether the status is one a notification ends in.
+func (s NotificationStatus) IsFinal() bool {
+	for _, final := range FinalStatuses {
+		if s == final {
+			return true
+		}
+	}
+	return false
+}
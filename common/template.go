@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	texttemplate "text/template"
+)
+
+// RenderedTemplate holds the output of a template. For email the body is
+// HTML with every data value escaped, for SMS it is plain text.
+type RenderedTemplate struct {
+	Subject string
+	Body    string
+}
+
+// ParseTemplate checks that the subject and body compile for the given channel.
+func ParseTemplate(t NotificationType, subject, body string) error {
+	_, _, err := parseTemplate(t, subject, body)
+	return err
+}
+
+// RenderTemplate executes the subject and body with data. A key referenced by
+// the template but missing from data is an error instead of an empty string.
+func RenderTemplate(t NotificationType, subject, body string, data map[string]interface{}) (RenderedTemplate, error) {
+	subjectTmpl, bodyTmpl, err := parseTemplate(t, subject, body)
+	if err != nil {
+		return RenderedTemplate{}, err
+	}
+
+	var subjectBuf, bodyBuf bytes.Buffer
+	if err := subjectTmpl.Execute(&subjectBuf, data); err != nil {
+		return RenderedTemplate{}, err
+	}
+	if err := bodyTmpl.Execute(&bodyBuf, data); err != nil {
+		return RenderedTemplate{}, err
+	}
+
+	return RenderedTemplate{Subject: subjectBuf.String(), Body: bodyBuf.String()}, nil
+}
+
+// executor is satisfied by both text/template and html/template templates.
+type executor interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+func parseTemplate(t NotificationType, subject, body string) (executor, executor, error) {
+	subjectTmpl, err := texttemplate.New("subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Email bodies are HTML, so values are escaped for the context they land in.
+	if t == EmailNotificationType {
+		bodyTmpl, err := htmltemplate.New("body").Option("missingkey=error").Parse(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return subjectTmpl, bodyTmpl, nil
+	}
+
+	bodyTmpl, err := texttemplate.New("body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subjectTmpl, bodyTmpl, nil
+}
@@ -0,0 +1,275 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxRecipients caps the user IDs of a single notification.
+	MaxRecipients = 100
+	// MaxEmailContentLength and MaxSmsContentLength are in characters. SMS
+	// providers split longer texts into at most ten segments.
+	MaxEmailContentLength = 100000
+	MaxSmsContentLength   = 1600
+	MaxSubjectLength      = 998
+	MaxCallbackURLLength  = 2048
+	MaxDigestKeyLength    = 200
+	MaxDedupeKeyLength    = 200
+)
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	e164Pattern  = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	contentLimit = map[NotificationType]int{
+		EmailNotificationType: MaxEmailContentLength,
+		SmsNotificationType:   MaxSmsContentLength,
+	}
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists every problem found in a request, not just the first.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fieldErr := range v {
+		messages[i] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field string, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func IsValidUUID(id string) bool {
+	return uuidPattern.MatchString(id)
+}
+
+// IsValidCallbackURL accepts absolute http and https URLs, except those that
+// name localhost or an IP that IsPublicIP rejects. Host names are checked
+// again after DNS resolution when the webhook is sent.
+func IsValidCallbackURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return IsPublicIP(ip)
+	}
+	return true
+}
+
+// IsPublicIP reports whether webhooks may be sent to ip. Loopback, private,
+// link-local and unspecified addresses reach the service's own network, such
+// as cloud metadata endpoints, and are rejected.
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified())
+}
+
+func IsValidE164(phone string) bool {
+	return e164Pattern.MatchString(phone)
+}
+
+// IsValidEmailAddress accepts a bare address such as "noreply@example.com".
+func IsValidEmailAddress(address string) bool {
+	parsed, err := mail.ParseAddress(address)
+	return err == nil && parsed.Address == address
+}
+
+// ValidateNotification checks a notification request and returns nil when it
+// is valid.
+func ValidateNotification(n Notification) ValidationErrors {
+	var errs ValidationErrors
+
+	if !IsValidType(n.Type) {
+		errs.add("type", "must be one of %q or %q", EmailNotificationType, SmsNotificationType)
+	}
+	if !IsValidPriority(n.Priority) {
+		errs.add("priority", "must be one of %q, %q, %q or %q", LowPriority, NormalPriority, HighPriority, CriticalPriority)
+	}
+
+	if !IsValidCategory(n.Category) {
+		errs.add("category", "must be %q or %q", TransactionalCategory, MarketingCategory)
+	}
+
+	validateRecipients(n.Type, n.To, &errs)
+
+	switch n.Type {
+	case EmailNotificationType:
+		if !IsValidEmailAddress(n.From) {
+			errs.add("from", "must be an email address")
+		}
+	case SmsNotificationType:
+		if !IsValidE164(n.From) {
+			errs.add("from", "must be a phone number in E.164 format, such as +15551234567")
+		}
+	}
+
+	if n.TemplateVersion < 0 {
+		errs.add("templateVersion", "must not be negative")
+	} else if n.TemplateVersion > 0 && n.TemplateID == "" {
+		errs.add("templateVersion", "requires templateId")
+	}
+
+	if n.CallbackURL != "" {
+		if len(n.CallbackURL) > MaxCallbackURLLength {
+			errs.add("callbackUrl", "must be at most %d characters", MaxCallbackURLLength)
+		} else if !IsValidCallbackURL(n.CallbackURL) {
+			errs.add("callbackUrl", "must be an absolute http or https URL to a public host")
+		}
+	}
+
+	if len(n.DigestKey) > MaxDigestKeyLength {
+		errs.add("digestKey", "must be at most %d characters", MaxDigestKeyLength)
+	}
+
+	if len(n.DedupeKey) > MaxDedupeKeyLength {
+		errs.add("dedupeKey", "must be at most %d characters", MaxDedupeKeyLength)
+	}
+
+	// Subject and content come from the template when one is referenced.
+	if n.TemplateID == "" {
+		if n.Type == EmailNotificationType && strings.TrimSpace(n.Subject) == "" {
+			errs.add("subject", "is required for email")
+		}
+		if strings.TrimSpace(n.Content) == "" && n.htmlBody() == "" {
+			errs.add("content", "is required")
+		}
+	}
+	if utf8.RuneCountInString(n.Subject) > MaxSubjectLength {
+		errs.add("subject", "must be at most %d characters", MaxSubjectLength)
+	}
+	if limit, ok := contentLimit[n.Type]; ok && utf8.RuneCountInString(n.Content) > limit {
+		errs.add("content", "must be at most %d characters for %s", limit, n.Type)
+	}
+	validateLocalized(n, &errs)
+	validateEmailOptions(n, &errs)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateLocalized checks the per-locale variants. Templates carry their own
+// variants, so a notification cannot have both.
+func validateLocalized(n Notification, errs *ValidationErrors) {
+	if len(n.Localized) == 0 {
+		return
+	}
+	if n.TemplateID != "" {
+		errs.add("localized", "cannot be combined with templateId, add the variants to the template instead")
+		return
+	}
+	locales := make([]string, 0, len(n.Localized))
+	for locale := range n.Localized {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		variant := n.Localized[locale]
+		field := "localized." + locale
+		if !IsValidLocale(locale) {
+			errs.add(field, "must be keyed by a locale such as bg or bg-BG")
+			continue
+		}
+		if variant.HTML != "" && n.Type != EmailNotificationType {
+			errs.add(field+".html", "is only valid for email notifications")
+		}
+		if strings.TrimSpace(variant.Content) == "" && strings.TrimSpace(variant.HTML) == "" {
+			errs.add(field+".content", "is required")
+		}
+		if utf8.RuneCountInString(variant.Subject) > MaxSubjectLength {
+			errs.add(field+".subject", "must be at most %d characters", MaxSubjectLength)
+		}
+		if limit, ok := contentLimit[n.Type]; ok && utf8.RuneCountInString(variant.Content) > limit {
+			errs.add(field+".content", "must be at most %d characters for %s", limit, n.Type)
+		}
+		if utf8.RuneCountInString(variant.HTML) > MaxEmailContentLength {
+			errs.add(field+".html", "must be at most %d characters", MaxEmailContentLength)
+		}
+	}
+}
+
+func validateRecipients(notificationType NotificationType, to []Recipient, errs *ValidationErrors) {
+	if len(to) == 0 {
+		errs.add("to", "must contain at least one recipient")
+		return
+	}
+	if len(to) > MaxRecipients {
+		errs.add("to", "must contain at most %d recipients", MaxRecipients)
+	}
+
+	seen := make(map[string]int, len(to))
+	for i, recipient := range to {
+		field := fmt.Sprintf("to[%d]", i)
+		set := 0
+		for _, value := range []string{recipient.UserID, recipient.Email, recipient.Phone} {
+			if value != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			errs.add(field, "must set exactly one of userId, email or phone")
+			continue
+		}
+
+		switch {
+		case recipient.UserID != "":
+			if !IsValidUUID(recipient.UserID) {
+				errs.add(field, "must be a UUID")
+				continue
+			}
+		case recipient.Email != "":
+			if notificationType == SmsNotificationType {
+				errs.add(field, "email recipients are not valid for sms")
+				continue
+			}
+			if !IsValidEmailAddress(recipient.Email) {
+				errs.add(field, "must be an email address")
+				continue
+			}
+		case recipient.Phone != "":
+			if notificationType == EmailNotificationType {
+				errs.add(field, "phone recipients are not valid for email")
+				continue
+			}
+			if !IsValidE164(recipient.Phone) {
+				errs.add(field, "must be a phone number in E.164 format, such as +15551234567")
+				continue
+			}
+		}
+
+		if recipient.Locale != "" && !IsValidLocale(recipient.Locale) {
+			errs.add(field+".locale", "must be a locale such as bg or bg-BG")
+			continue
+		}
+
+		key := recipient.Key()
+		if first, ok := seen[key]; ok {
+			errs.add(field, "duplicates to[%d]", first)
+			continue
+		}
+		seen[key] = i
+	}
+}
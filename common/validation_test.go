@@ -0,0 +1,271 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsValidCallbackURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/hooks", true},
+		{"http://hooks.example.com:8080/a?b=c", true},
+		{"https://93.184.216.34/hook", true},
+		{"ftp://example.com/hook", false},
+		{"/relative", false},
+		{"https://", false},
+		{"http://localhost:8080/hook", false},
+		{"http://LOCALHOST./hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://172.20.0.1/hook", false},
+		{"http://192.168.0.10/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://[fd12::1]/hook", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := IsValidCallbackURL(tt.url); got != tt.valid {
+				t.Errorf("IsValidCallbackURL(%q) = %v, want %v", tt.url, got, tt.valid)
+			}
+		})
+	}
+}
+
+// validEmail and validSMS return notifications that pass validation, for the
+// tables below to break one field at a time.
+func validEmail() Notification {
+	return Notification{
+		Type:    EmailNotificationType,
+		To:      []Recipient{UserRecipient("563cfe60-6ed7-49ac-ba33-f05758831980")},
+		From:    "noreply@example.com",
+		Subject: "Hello",
+		Content: "Hi there",
+	}
+}
+
+func validSMS() Notification {
+	return Notification{
+		Type:    SmsNotificationType,
+		To:      []Recipient{{Phone: "+359890123456"}},
+		From:    "+15551234567",
+		Content: "Hi there",
+	}
+}
+
+// errorFields lists the fields of errs in order.
+func errorFields(errs ValidationErrors) []string {
+	var fields []string
+	for _, fieldErr := range errs {
+		fields = append(fields, fieldErr.Field)
+	}
+	return fields
+}
+
+func checkFields(t *testing.T, errs ValidationErrors, want []string) {
+	t.Helper()
+	got := errorFields(errs)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("errors on %v, want %v (%v)", got, want, errs)
+	}
+}
+
+func TestValidateNotification(t *testing.T) {
+	tests := []struct {
+		name         string
+		notification func() Notification
+		fields       []string
+	}{
+		{"valid email", validEmail, nil},
+		{"valid sms", validSMS, nil},
+		{"unknown type", func() Notification { n := validEmail(); n.Type = "push"; return n }, []string{"type"}},
+		{"unknown priority", func() Notification { n := validEmail(); n.Priority = "urgent"; return n }, []string{"priority"}},
+		{"unknown category", func() Notification { n := validEmail(); n.Category = "news"; return n }, []string{"category"}},
+		{"no recipients", func() Notification { n := validEmail(); n.To = nil; return n }, []string{"to"}},
+		{"recipient with two addresses", func() Notification {
+			n := validEmail()
+			n.To = []Recipient{{Email: "a@example.com", Phone: "+359890123456"}}
+			return n
+		}, []string{"to[0]"}},
+		{"recipient user ID", func() Notification { n := validEmail(); n.To = []Recipient{UserRecipient("42")}; return n }, []string{"to[0]"}},
+		{"email recipient for sms", func() Notification { n := validSMS(); n.To = []Recipient{{Email: "a@example.com"}}; return n }, []string{"to[0]"}},
+		{"phone recipient for email", func() Notification { n := validEmail(); n.To = []Recipient{{Phone: "+359890123456"}}; return n }, []string{"to[0]"}},
+		{"email sender", func() Notification { n := validEmail(); n.From = "Example <noreply@example.com>"; return n }, []string{"from"}},
+		{"sms sender", func() Notification { n := validSMS(); n.From = "0888123456"; return n }, []string{"from"}},
+		{"email without subject", func() Notification { n := validEmail(); n.Subject = " "; return n }, []string{"subject"}},
+		{"without content", func() Notification { n := validSMS(); n.Content = ""; return n }, []string{"content"}},
+		{"html instead of content", func() Notification {
+			n := validEmail()
+			n.Content = ""
+			n.Email = &EmailOptions{HTML: "<p>Hi</p>"}
+			return n
+		}, nil},
+		{"sms content too long", func() Notification {
+			n := validSMS()
+			n.Content = strings.Repeat("я", MaxSmsContentLength+1)
+			return n
+		}, []string{"content"}},
+		{"template instead of content", func() Notification {
+			n := validEmail()
+			n.Subject, n.Content, n.TemplateID, n.TemplateVersion = "", "", "welcome", 2
+			return n
+		}, nil},
+		{"template version without template", func() Notification { n := validEmail(); n.TemplateVersion = 2; return n }, []string{"templateVersion"}},
+		{"negative template version", func() Notification {
+			n := validEmail()
+			n.TemplateID, n.TemplateVersion = "welcome", -1
+			return n
+		}, []string{"templateVersion"}},
+		{"private callback URL", func() Notification { n := validEmail(); n.CallbackURL = "http://10.0.0.1/hook"; return n }, []string{"callbackUrl"}},
+		{"long digest key", func() Notification {
+			n := validEmail()
+			n.DigestKey = strings.Repeat("k", MaxDigestKeyLength+1)
+			return n
+		}, []string{"digestKey"}},
+		{"long dedupe key", func() Notification {
+			n := validEmail()
+			n.DedupeKey = strings.Repeat("k", MaxDedupeKeyLength+1)
+			return n
+		}, []string{"dedupeKey"}},
+		{"every problem is reported", func() Notification { return Notification{Type: EmailNotificationType} }, []string{"to", "from", "subject", "content"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateNotification(tt.notification())
+			if tt.fields == nil && errs != nil {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			checkFields(t, errs, tt.fields)
+		})
+	}
+}
+
+func TestValidateLocalized(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  bool
+		sms       bool
+		localized map[string]LocalizedContent
+		fields    []string
+	}{
+		{"none", false, false, nil, nil},
+		{"valid variants", false, false, map[string]LocalizedContent{"bg": {Subject: "Здравей", Content: "Здравей"}, "en-GB": {HTML: "<p>Hello</p>"}}, nil},
+		{"with a template", true, false, map[string]LocalizedContent{"bg": {Content: "Здравей"}}, []string{"localized"}},
+		{"invalid locale", false, false, map[string]LocalizedContent{"bulgarian!": {Content: "Здравей"}}, []string{"localized.bulgarian!"}},
+		{"empty variant", false, false, map[string]LocalizedContent{"bg": {Subject: "Здравей"}}, []string{"localized.bg.content"}},
+		{"html for sms", false, true, map[string]LocalizedContent{"bg": {Content: "Здравей", HTML: "<p>Здравей</p>"}}, []string{"localized.bg.html"}},
+		{"sms content too long", false, true, map[string]LocalizedContent{"bg": {Content: strings.Repeat("я", MaxSmsContentLength+1)}}, []string{"localized.bg.content"}},
+		{"subject too long", false, false, map[string]LocalizedContent{"bg": {Subject: strings.Repeat("я", MaxSubjectLength+1), Content: "Здравей"}}, []string{"localized.bg.subject"}},
+		{"sorted by locale", false, false, map[string]LocalizedContent{"fr": {}, "bg": {}}, []string{"localized.bg.content", "localized.fr.content"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := validEmail()
+			if tt.sms {
+				n = validSMS()
+			}
+			if tt.template {
+				n.TemplateID = "welcome"
+			}
+			n.Localized = tt.localized
+			var errs ValidationErrors
+			validateLocalized(n, &errs)
+			checkFields(t, errs, tt.fields)
+		})
+	}
+}
+
+func TestValidateEmailOptions(t *testing.T) {
+	tooMany := make([]string, MaxRecipients)
+	for i := range tooMany {
+		tooMany[i] = "user@example.com"
+	}
+	tooManyHeaders := make(map[string]string, MaxEmailHeaders+1)
+	for i := 0; i <= MaxEmailHeaders; i++ {
+		tooManyHeaders[fmt.Sprintf("X-Header-%d", i)] = "value"
+	}
+
+	tests := []struct {
+		name     string
+		sms      bool
+		template bool
+		options  *EmailOptions
+		fields   []string
+	}{
+		{"none", false, false, nil, nil},
+		{"valid options", false, false, &EmailOptions{
+			FromName: "Example",
+			ReplyTo:  "support@example.com",
+			Cc:       []string{"cc@example.com"},
+			Bcc:      []string{"bcc@example.com"},
+			Headers:  map[string]string{"X-Campaign": "spring"},
+		}, nil},
+		{"on sms", true, false, &EmailOptions{FromName: "Example"}, []string{"email"}},
+		{"html with a template", false, true, &EmailOptions{HTML: "<p>Hi</p>"}, []string{"email.html"}},
+		{"html too long", false, false, &EmailOptions{HTML: strings.Repeat("a", MaxEmailContentLength+1)}, []string{"email.html"}},
+		{"from name too long", false, false, &EmailOptions{FromName: strings.Repeat("a", MaxFromNameLength+1)}, []string{"email.fromName"}},
+		{"from name with a line break", false, false, &EmailOptions{FromName: "Example\r\nBcc: x@example.com"}, []string{"email.fromName"}},
+		{"reply-to", false, false, &EmailOptions{ReplyTo: "support"}, []string{"email.replyTo"}},
+		{"cc and bcc addresses", false, false, &EmailOptions{Cc: []string{"ok@example.com", "nope"}, Bcc: []string{"nope"}}, []string{"email.cc[1]", "email.bcc[0]"}},
+		{"too many recipients", false, false, &EmailOptions{Cc: tooMany}, []string{"email"}},
+		{"header name", false, false, &EmailOptions{Headers: map[string]string{"X Campaign": "spring"}}, []string{"email.headers.X Campaign"}},
+		{"reserved header", false, false, &EmailOptions{Headers: map[string]string{"reply-to": "a@example.com"}}, []string{"email.headers.reply-to"}},
+		{"header value too long", false, false, &EmailOptions{Headers: map[string]string{"X-Long": strings.Repeat("a", MaxEmailHeaderLength+1)}}, []string{"email.headers.X-Long"}},
+		{"header value with a line break", false, false, &EmailOptions{Headers: map[string]string{"X-Campaign": "a\nBcc: x@example.com"}}, []string{"email.headers.X-Campaign"}},
+		{"too many headers", false, false, &EmailOptions{Headers: tooManyHeaders}, []string{"email.headers"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := validEmail()
+			if tt.sms {
+				n = validSMS()
+			}
+			if tt.template {
+				n.TemplateID = "welcome"
+			}
+			n.Email = tt.options
+			var errs ValidationErrors
+			validateEmailOptions(n, &errs)
+			checkFields(t, errs, tt.fields)
+		})
+	}
+}
+
+func TestValidateAttachments(t *testing.T) {
+	const id = "7d1c1f0e-3b7a-4c55-9d0e-2a6b1c2b7e11"
+	tooMany := make([]Attachment, MaxAttachments+1)
+	for i := range tooMany {
+		tooMany[i] = Attachment{ID: id}
+	}
+
+	tests := []struct {
+		name        string
+		attachments []Attachment
+		fields      []string
+	}{
+		{"none", nil, nil},
+		{"by ID", []Attachment{{ID: id}, {ID: id, Filename: "invoice.pdf"}}, nil},
+		{"by content", []Attachment{{Filename: "invoice.pdf", Content: "aGVsbG8="}}, nil},
+		{"too many", tooMany, []string{"email.attachments"}},
+		{"neither ID nor content", []Attachment{{Filename: "invoice.pdf"}}, []string{"email.attachments[0]"}},
+		{"both ID and content", []Attachment{{ID: id, Filename: "invoice.pdf", Content: "aGVsbG8="}}, []string{"email.attachments[0]"}},
+		{"ID", []Attachment{{ID: "invoice"}}, []string{"email.attachments[0].id"}},
+		{"content without a name", []Attachment{{Content: "aGVsbG8="}}, []string{"email.attachments[0].filename"}},
+		{"content too large", []Attachment{{Filename: "big.bin", Content: strings.Repeat("A", (MaxAttachmentSize/3+2)*4)}}, []string{"email.attachments[0].content"}},
+		{"name with a path", []Attachment{{ID: id, Filename: "../etc/passwd"}}, []string{"email.attachments[0].filename"}},
+		{"name with a quote", []Attachment{{Filename: "a\".pdf", Content: "aGVsbG8="}}, []string{"email.attachments[0].filename"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ValidationErrors
+			validateAttachments(tt.attachments, &errs)
+			checkFields(t, errs, tt.fields)
+		})
+	}
+}
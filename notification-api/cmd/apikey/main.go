@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+const usage = `Usage:
+  apikey issue -name NAME -scopes send,read,admin
+  apikey list
+  apikey revoke -id ID`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	pool, err := db.Connect(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+	repo := db.NewAPIKeyRepository(pool)
+
+	switch os.Args[1] {
+	case "issue":
+		flags := flag.NewFlagSet("issue", flag.ExitOnError)
+		name := flags.String("name", "", "name of the caller the key belongs to")
+		scopes := flags.String("scopes", auth.ScopeSend, "comma separated scopes: send, read, admin")
+		flags.Parse(os.Args[2:])
+
+		plaintext, key, err := auth.IssueKey(ctx, repo, *name, strings.Split(*scopes, ","))
+		if err != nil {
+			log.Fatalf("Failed to issue API key: %v", err)
+		}
+		fmt.Printf("Issued API key %s (%s) with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println("Store it now, it cannot be shown again:")
+		fmt.Println(plaintext)
+	case "list":
+		keys, err := repo.ListAPIKeys(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list API keys: %v", err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tPREFIX\tSCOPES\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.RevokedAt != nil)
+		}
+		writer.Flush()
+	case "revoke":
+		flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+		id := flags.String("id", "", "id of the key to revoke")
+		flags.Parse(os.Args[2:])
+
+		if err := repo.RevokeAPIKey(ctx, *id); err != nil {
+			log.Fatalf("Failed to revoke API key: %v", err)
+		}
+		fmt.Printf("Revoked API key %s\n", *id)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type issuedAPIKey struct {
+	*db.APIKey
+	Key string `json:"key"`
+}
+
+// apiKeysHandler lists API keys on GET and issues a new key on POST. The
+// plaintext key is part of the POST response only.
+func apiKeysHandler(apiKeyRepo db.APIKeyRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := apiKeyRepo.ListAPIKeys(r.Context())
+			if err != nil {
+				log.Printf("Error listing API keys: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, keys)
+		case http.MethodPost:
+			var request apiKeyRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				log.Printf("Invalid request body: %v", err)
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if request.Name == "" || len(request.Scopes) == 0 {
+				http.Error(w, "API key name and scopes are required", http.StatusBadRequest)
+				return
+			}
+			for _, scope := range request.Scopes {
+				if !auth.IsValidScope(scope) {
+					http.Error(w, "Invalid scope: "+scope, http.StatusBadRequest)
+					return
+				}
+			}
+
+			plaintext, key, err := auth.IssueKey(r.Context(), apiKeyRepo, request.Name, request.Scopes)
+			if err != nil {
+				log.Printf("Error issuing API key: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusCreated, issuedAPIKey{APIKey: key, Key: plaintext})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// apiKeyByIdHandler revokes an API key on DELETE.
+func apiKeyByIdHandler(apiKeyRepo db.APIKeyRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/v1/admin/api-keys/")
+		if _, err := uuid.Parse(id); err != nil {
+			http.Error(w, "Invalid API key id", http.StatusBadRequest)
+			return
+		}
+
+		err := apiKeyRepo.RevokeAPIKey(r.Context(), id)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error revoking API key %s: %v", id, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+// maxUploadOverhead leaves room for the multipart framing around the file.
+const maxUploadOverhead = 1 << 20
+
+// attachmentsHandler stores a file sent as the "file" field of a
+// multipart/form-data POST, for notifications to reference by ID.
+func attachmentsHandler(attachmentRepo db.AttachmentRepository, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, common.MaxAttachmentSize+maxUploadOverhead)
+		file, header, err := r.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("Attachment must be at most %d bytes", common.MaxAttachmentSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("Invalid attachment upload: %v", err)
+			http.Error(w, "Request must be multipart/form-data with a file field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		filename := filepath.Base(header.Filename)
+		if !common.IsValidAttachmentFilename(filename) {
+			http.Error(w, "Invalid attachment filename", http.StatusBadRequest)
+			return
+		}
+		content, err := io.ReadAll(io.LimitReader(file, common.MaxAttachmentSize+1))
+		if err != nil {
+			log.Printf("Error reading attachment upload: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(content) > common.MaxAttachmentSize {
+			http.Error(w, fmt.Sprintf("Attachment must be at most %d bytes", common.MaxAttachmentSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if len(content) == 0 {
+			http.Error(w, "Attachment is empty", http.StatusBadRequest)
+			return
+		}
+
+		attachment, err := attachmentRepo.CreateAttachment(r.Context(), apiKeyIDFromRequest(r), filename, sniffContentType(filename, content), content, time.Now().Add(retention))
+		if err != nil {
+			log.Printf("Error storing attachment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, attachment)
+	}
+}
+
+// attachmentByIdHandler returns an attachment's metadata on GET and deletes
+// it on DELETE. Callers only see their own attachments.
+func attachmentByIdHandler(attachmentRepo db.AttachmentRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/attachments/")
+		if _, err := uuid.Parse(id); err != nil {
+			http.Error(w, "Invalid attachment id", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			attachment, err := attachmentRepo.GetAttachment(r.Context(), apiKeyIDFromRequest(r), id)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Attachment not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error fetching attachment %s: %v", id, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, attachment)
+		case http.MethodDelete:
+			err := attachmentRepo.DeleteAttachment(r.Context(), apiKeyIDFromRequest(r), id)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Attachment not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting attachment %s: %v", id, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// pendingAttachment is an attachment of a notification that was checked but
+// not stored yet. Content is only set for files sent inline.
+type pendingAttachment struct {
+	common.Attachment
+	content     []byte
+	contentType string
+}
+
+// prepareAttachments decodes the base64 attachments of a notification and
+// checks that referenced attachments belong to the caller and that the files
+// fit the size limits. It writes nothing, so a request rejected afterwards
+// leaves no files behind. Problems with the request are returned as
+// validation errors.
+func prepareAttachments(ctx context.Context, attachmentRepo db.AttachmentRepository, notification common.Notification) ([]pendingAttachment, common.ValidationErrors, error) {
+	if notification.Email == nil || len(notification.Email.Attachments) == 0 {
+		return nil, nil, nil
+	}
+
+	var errs common.ValidationErrors
+	pending := make([]pendingAttachment, len(notification.Email.Attachments))
+	total := 0
+	for i, attachment := range notification.Email.Attachments {
+		field := fmt.Sprintf("email.attachments[%d]", i)
+		pending[i].Attachment = attachment
+
+		if attachment.Content != "" {
+			content, err := base64.StdEncoding.DecodeString(attachment.Content)
+			if err != nil {
+				errs = append(errs, common.FieldError{Field: field + ".content", Message: "must be base64 encoded"})
+				continue
+			}
+			if len(content) == 0 || len(content) > common.MaxAttachmentSize {
+				errs = append(errs, common.FieldError{Field: field + ".content", Message: fmt.Sprintf("must be between 1 and %d bytes", common.MaxAttachmentSize)})
+				continue
+			}
+			pending[i].Content = ""
+			pending[i].content = content
+			pending[i].contentType = sniffContentType(attachment.Filename, content)
+			total += len(content)
+			continue
+		}
+
+		stored, err := attachmentRepo.GetAttachment(ctx, notification.APIKeyID, attachment.ID)
+		if errors.Is(err, db.ErrNotFound) {
+			errs = append(errs, common.FieldError{Field: field + ".id", Message: "is not a known attachment"})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		total += stored.Size
+	}
+	if total > common.MaxAttachmentsSize {
+		errs = append(errs, common.FieldError{Field: "email.attachments", Message: fmt.Sprintf("must be at most %d bytes together", common.MaxAttachmentsSize)})
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+	return pending, nil, nil
+}
+
+// storeAttachments stores the prepared inline files of a notification and
+// replaces them with their IDs. Every file is kept for the retention past the
+// notification's send time. A referenced file deleted since it was prepared
+// is reported as a validation error.
+func storeAttachments(ctx context.Context, attachmentRepo db.AttachmentRepository, retention time.Duration, notification *common.Notification, pending []pendingAttachment) (common.ValidationErrors, error) {
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	expiresAt := time.Now().Add(retention)
+	if notification.IsScheduled() {
+		expiresAt = notification.SendAt.Add(retention)
+	}
+
+	attachments := make([]common.Attachment, len(pending))
+	for i, attachment := range pending {
+		if attachment.content != nil {
+			stored, err := attachmentRepo.CreateAttachment(ctx, notification.APIKeyID, attachment.Filename, attachment.contentType, attachment.content, expiresAt)
+			if err != nil {
+				return nil, err
+			}
+			// The stored file already has this name.
+			attachments[i] = common.Attachment{ID: stored.ID}
+			continue
+		}
+
+		_, err := attachmentRepo.KeepAttachment(ctx, notification.APIKeyID, attachment.ID, expiresAt)
+		if errors.Is(err, db.ErrNotFound) {
+			return common.ValidationErrors{{Field: fmt.Sprintf("email.attachments[%d].id", i), Message: "is not a known attachment"}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		attachments[i] = attachment.Attachment
+	}
+
+	options := *notification.Email
+	options.Attachments = attachments
+	notification.Email = &options
+	return nil, nil
+}
+
+// purgeAttachments periodically removes expired attachments until the context
+// is cancelled.
+func purgeAttachments(ctx context.Context, attachmentRepo db.AttachmentRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := attachmentRepo.DeleteExpired(ctx)
+			if err != nil {
+				log.Printf("Error purging attachments: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Purged %d expired attachments", deleted)
+			}
+		}
+	}
+}
+
+// sniffContentType detects a file's MIME type from its content. Content that
+// only sniffs as generic text or binary is typed by its file extension when
+// the system knows it.
+func sniffContentType(filename string, content []byte) string {
+	sniffed := http.DetectContentType(content)
+	mediaType, _, _ := mime.ParseMediaType(sniffed)
+	if mediaType == "application/octet-stream" || mediaType == "text/plain" {
+		if byExtension := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExtension != "" {
+			return byExtension
+		}
+	}
+	return sniffed
+}
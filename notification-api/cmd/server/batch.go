@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+	"github.com/pdragnev/notification-system/notification-api/internal/metrics"
+	"github.com/pdragnev/notification-system/notification-api/internal/notifications"
+	"github.com/pdragnev/notification-system/notification-api/internal/ratelimit"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+type batchItemResult struct {
+	Index  int                       `json:"index"`
+	ID     string                    `json:"id,omitempty"`
+	Status common.NotificationStatus `json:"status,omitempty"`
+	Error  string                    `json:"error,omitempty"`
+	Errors common.ValidationErrors   `json:"errors,omitempty"`
+}
+
+type batchResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Results  []batchItemResult `json:"results"`
+}
+
+// batchNotificationHandler accepts a JSON array of notifications, or one
+// notification per line when the body is sent as application/x-ndjson.
+// Invalid items are reported individually and do not fail the whole batch.
+func batchNotificationHandler(notificationService *notifications.NotificationService, limiter *ratelimit.Limiter, templateRepo db.TemplateRepository, webhookRepo db.WebhookRepository, attachmentRepo db.AttachmentRepository, attachmentRetention time.Duration, maxBatchSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed", "", nil)
+			return
+		}
+
+		var items []json.RawMessage
+		var err error
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == ndjsonContentType {
+			items, err = readNDJSON(r.Body, maxBatchSize)
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&items)
+		}
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		if len(items) == 0 {
+			writeProblem(w, r, http.StatusBadRequest, "Empty batch", "The batch must contain at least one notification.", nil)
+			return
+		}
+		if len(items) > maxBatchSize {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "Batch too large", fmt.Sprintf("The batch must not contain more than %d notifications.", maxBatchSize), nil)
+			return
+		}
+
+		apiKeyID := apiKeyIDFromRequest(r)
+		var subscribed *bool
+		templates := make(map[templateRef]*db.Template)
+
+		response := batchResponse{Results: make([]batchItemResult, len(items))}
+		var valid []common.Notification
+		var validIndexes []int
+		var attachments [][]pendingAttachment
+		for i, item := range items {
+			response.Results[i].Index = i
+
+			var notification common.Notification
+			if err := json.Unmarshal(item, &notification); err != nil {
+				response.Results[i].Error = "Invalid notification body"
+				continue
+			}
+			if errs := common.ValidateNotification(notification); errs != nil {
+				response.Results[i].Error = "Invalid notification"
+				response.Results[i].Errors = errs
+				continue
+			}
+
+			notification.APIKeyID = apiKeyID
+			errs, err := pinTemplate(r.Context(), templateRepo, templates, &notification)
+			if err != nil {
+				log.Printf("Error fetching template %s: %v", notification.TemplateID, err)
+				writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+				return
+			}
+			if errs != nil {
+				response.Results[i].Error = "Invalid notification"
+				response.Results[i].Errors = errs
+				continue
+			}
+			if notification.CallbackURL != "" {
+				if subscribed == nil {
+					ok, err := hasWebhookSubscription(r.Context(), webhookRepo, apiKeyID)
+					if err != nil {
+						log.Printf("Error checking webhook subscription: %v", err)
+						writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+						return
+					}
+					subscribed = &ok
+				}
+				if !*subscribed {
+					response.Results[i].Error = "Invalid notification"
+					response.Results[i].Errors = common.ValidationErrors{callbackURLError}
+					continue
+				}
+			}
+			pending, errs, err := prepareAttachments(r.Context(), attachmentRepo, notification)
+			if err != nil {
+				log.Printf("Error checking attachments: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+				return
+			}
+			if errs != nil {
+				response.Results[i].Error = "Invalid notification"
+				response.Results[i].Errors = errs
+				continue
+			}
+			valid = append(valid, notification)
+			validIndexes = append(validIndexes, i)
+			attachments = append(attachments, pending)
+		}
+
+		// The batch spends one token per valid item from each type's bucket,
+		// and nothing when any bucket is short.
+		costs := map[common.NotificationType]int{}
+		for _, notification := range valid {
+			costs[notification.Type]++
+		}
+		metrics.SetNotificationType(r, batchType(costs))
+		if len(costs) > 0 && !checkRateLimit(w, r, limiter, costs) {
+			return
+		}
+
+		// Files are only stored once the batch is admitted.
+		failed := false
+		var accepted []common.Notification
+		var acceptedIndexes []int
+		for j, notification := range valid {
+			i := validIndexes[j]
+			errs, err := storeAttachments(r.Context(), attachmentRepo, attachmentRetention, &notification, attachments[j])
+			if err != nil {
+				log.Printf("Error storing attachments: %v", err)
+				response.Results[i].Error = "Internal server error"
+				failed = true
+				continue
+			}
+			if errs != nil {
+				response.Results[i].Error = "Invalid notification"
+				response.Results[i].Errors = errs
+				continue
+			}
+			accepted = append(accepted, notification)
+			acceptedIndexes = append(acceptedIndexes, i)
+		}
+
+		for j, result := range notificationService.SendNotifications(accepted) {
+			i := acceptedIndexes[j]
+			if result.Err != nil {
+				response.Results[i].Error = "Internal server error"
+				failed = true
+				continue
+			}
+			response.Results[i].ID = result.ID
+			response.Results[i].Status = result.Status
+		}
+
+		for _, result := range response.Results {
+			if result.Error != "" {
+				response.Rejected++
+			} else {
+				response.Accepted++
+			}
+		}
+
+		// A batch that was rejected as a whole is only the caller's fault
+		// when no item failed on our side.
+		status := http.StatusAccepted
+		switch {
+		case response.Accepted > 0:
+		case failed:
+			status = http.StatusInternalServerError
+		default:
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, response)
+	}
+}
+
+// batchType labels a batch with its notification type, or "mixed" when it
+// carries more than one.
+func batchType(costs map[common.NotificationType]int) string {
+	if len(costs) > 1 {
+		return "mixed"
+	}
+	for notificationType := range costs {
+		return string(notificationType)
+	}
+	return "unknown"
+}
+
+// readNDJSON reads one JSON document per line, skipping blank lines.
+// It stops early once more than maxItems lines were read.
+func readNDJSON(body io.Reader, maxItems int) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(body)
+	// A line holds one notification, attachments included.
+	scanner.Buffer(make([]byte, 64*1024), int(maxNotificationBodySize))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+		if len(items) > maxItems {
+			break
+		}
+	}
+	return items, scanner.Err()
+}
@@ -2,41 +2,312 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// The alpine images ship without zoneinfo, and quiet hours need it.
+	_ "time/tzdata"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+	"github.com/pdragnev/notification-system/notification-api/internal/health"
+	"github.com/pdragnev/notification-system/notification-api/internal/idempotency"
+	"github.com/pdragnev/notification-system/notification-api/internal/metrics"
 	"github.com/pdragnev/notification-system/notification-api/internal/notifications"
+	"github.com/pdragnev/notification-system/notification-api/internal/outbox"
 	"github.com/pdragnev/notification-system/notification-api/internal/queue"
+	"github.com/pdragnev/notification-system/notification-api/internal/ratelimit"
+	"github.com/pdragnev/notification-system/notification-api/internal/scheduler"
 )
 
-func notificationHandler(notificationService common.NotificationService) http.HandlerFunc {
+// maxNotificationBodySize bounds a notification request: its attachments as
+// base64 plus room for the content and other fields.
+var maxNotificationBodySize = int64(base64.StdEncoding.EncodedLen(common.MaxAttachmentsSize)) + 1<<20
+
+// limitBody rejects request bodies over max bytes. It wraps the idempotency
+// middleware, which reads the whole body into memory.
+func limitBody(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "Request body too large", fmt.Sprintf("The request body must not be larger than %d bytes.", max), nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError answers a request whose body could not be decoded.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, "Request body too large", fmt.Sprintf("The request body must not be larger than %d bytes.", maxBytesErr.Limit), nil)
+		return
+	}
+	log.Printf("Invalid request body: %v", err)
+	writeProblem(w, r, http.StatusBadRequest, "Invalid request body", err.Error(), nil)
+}
+
+func notificationHandler(notificationService common.NotificationService, limiter *ratelimit.Limiter, templateRepo db.TemplateRepository, webhookRepo db.WebhookRepository, attachmentRepo db.AttachmentRepository, attachmentRetention time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var notification common.Notification
 		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
-			log.Printf("Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeBodyError(w, r, err)
+			return
+		}
+
+		metrics.SetNotificationType(r, string(notification.Type))
+
+		if errs := common.ValidateNotification(notification); errs != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid notification", "The notification failed validation.", errs)
+			return
+		}
+
+		notification.APIKeyID = apiKeyIDFromRequest(r)
+		errs, err := pinTemplate(r.Context(), templateRepo, nil, &notification)
+		if err != nil {
+			log.Printf("Error fetching template %s: %v", notification.TemplateID, err)
+			writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+			return
+		}
+		if errs != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid notification", "The notification failed validation.", errs)
+			return
+		}
+		if notification.CallbackURL != "" {
+			subscribed, err := hasWebhookSubscription(r.Context(), webhookRepo, notification.APIKeyID)
+			if err != nil {
+				log.Printf("Error checking webhook subscription: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+				return
+			}
+			if !subscribed {
+				writeProblem(w, r, http.StatusBadRequest, "Invalid notification", "The notification failed validation.", common.ValidationErrors{callbackURLError})
+				return
+			}
+		}
+
+		attachments, errs, err := prepareAttachments(r.Context(), attachmentRepo, notification)
+		if err != nil {
+			log.Printf("Error checking attachments: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+			return
+		}
+		if errs != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid notification", "The notification failed validation.", errs)
+			return
+		}
+
+		if !checkRateLimit(w, r, limiter, map[common.NotificationType]int{notification.Type: 1}) {
 			return
 		}
 
-		if !common.IsValidType(notification.Type) {
-			http.Error(w, "Invalid notification type", http.StatusBadRequest)
+		// Files are only stored once the request is accepted.
+		errs, err = storeAttachments(r.Context(), attachmentRepo, attachmentRetention, &notification, attachments)
+		if err != nil {
+			log.Printf("Error storing attachments: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+			return
+		}
+		if errs != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid notification", "The notification failed validation.", errs)
 			return
 		}
 
-		if err := notificationService.SendNotification(notification); err != nil {
+		id, err := notificationService.SendNotification(notification)
+		if err != nil {
 			log.Printf("Error sending notification: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Internal server error", "", nil)
+			return
+		}
+
+		status := common.QueuedStatus
+		if notification.IsScheduled() {
+			status = common.ScheduledStatus
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"id":     id,
+			"status": status,
+		})
+	}
+}
+
+// checkRateLimit spends the cost of each notification type and answers 429
+// when the caller is over its limit. A failing rate limit backend lets the
+// request through.
+func checkRateLimit(w http.ResponseWriter, r *http.Request, limiter *ratelimit.Limiter, costs map[common.NotificationType]int) bool {
+	result, err := limiter.AllowRequests(r, costs)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		return true
+	}
+
+	ratelimit.WriteHeaders(w, result)
+	if !result.Allowed {
+		writeProblem(w, r, http.StatusTooManyRequests, "Rate limit exceeded", "Retry after "+w.Header().Get("Retry-After")+" seconds.", nil)
+		return false
+	}
+	return true
+}
+
+// notificationByIdHandler reports the lifecycle state of a notification on GET
+// and cancels a scheduled notification on DELETE. Callers only see their own
+// notifications.
+func notificationByIdHandler(notificationRepo db.NotificationRepository, scheduleRepo db.ScheduleRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/notifications/")
+		if _, err := uuid.Parse(id); err != nil {
+			http.Error(w, "Invalid notification id", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			err := scheduleRepo.Cancel(r.Context(), apiKeyIDFromRequest(r), id)
+			if errors.Is(err, db.ErrNotScheduled) {
+				writeNotScheduled(w, r, notificationRepo, id)
+				return
+			}
+			if err != nil {
+				log.Printf("Error cancelling notification %s: %v", id, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		record, err := notificationRepo.GetNotificationById(r.Context(), apiKeyIDFromRequest(r), id)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Notification not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching notification %s: %v", id, err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Notification enqueued successfully"))
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+// writeNotScheduled tells apart an unknown id from a notification that
+// already left the schedule and can no longer be cancelled.
+func writeNotScheduled(w http.ResponseWriter, r *http.Request, notificationRepo db.NotificationRepository, id string) {
+	_, err := notificationRepo.GetNotificationById(r.Context(), apiKeyIDFromRequest(r), id)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Notification not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Error fetching notification %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		http.Error(w, "Notification is no longer scheduled", http.StatusConflict)
+	}
+}
+
+// problem is an RFC 7807 problem document. Errors is an extension member
+// listing every invalid field.
+type problem struct {
+	Type     string                  `json:"type"`
+	Title    string                  `json:"title"`
+	Status   int                     `json:"status"`
+	Detail   string                  `json:"detail,omitempty"`
+	Instance string                  `json:"instance,omitempty"`
+	Errors   common.ValidationErrors `json:"errors,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, title string, detail string, errs common.ValidationErrors) {
+	problemType := "about:blank"
+	if len(errs) > 0 {
+		problemType = "/problems/validation-error"
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(problem{
+		Type:     problemType,
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   errs,
+	})
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s value: %v", name, err)
+	}
+	return duration
+}
+
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s value: %v", name, err)
+	}
+	return parsed
+}
+
+func limitFromEnv(name string, fallback string) ratelimit.Limit {
+	value := os.Getenv(name)
+	if value == "" {
+		value = fallback
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Invalid %s value: %v", name, err)
+	}
+	return limit
+}
+
+// rateLimitStore picks the bucket backend. Postgres is shared by every API
+// replica, memory is only correct for a single replica.
+func rateLimitStore(pool *pgxpool.Pool) ratelimit.Store {
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "postgres":
+		return ratelimit.NewPostgresStore(pool)
+	case "memory":
+		return ratelimit.NewMemoryStore()
+	default:
+		log.Fatalf("Invalid RATE_LIMIT_BACKEND value: %s", backend)
+		return nil
 	}
 }
 
@@ -50,19 +321,118 @@ func main() {
 		DLXExchange:       os.Getenv("DLX_EXCHANGE_NAME"),
 		DLXQueue:          os.Getenv("DLX_QUEUE_NAME"),
 		NotificationQueue: os.Getenv("RABBITMQ_NOTIFICATION_QUEUE_NAME"),
+		ConfirmTimeout:    durationFromEnv("PUBLISH_CONFIRM_TIMEOUT", 5*time.Second),
+		ChannelPoolSize:   intFromEnv("PUBLISH_CHANNEL_POOL_SIZE", 16),
+	}
+
+	pool, err := db.Connect(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	notificationRepository := db.NewNotificationRepository(pool)
+	scheduleRepository := db.NewScheduleRepository(pool)
+	templateRepository := db.NewTemplateRepository(pool)
+
+	outboxRepository := db.NewOutboxRepository(pool)
+
+	notificationService, err := notifications.NewNotificationService(notificationQueueName, config, notificationRepository, scheduleRepository, outboxRepository)
+	if err != nil {
+		log.Fatalf("Failed to initialize notification service: %v", err)
 	}
-	notificationService, err := notifications.NewNotificationService(notificationQueueName, config)
 	if err := notificationService.QueueClient.SetupQueues(); err != nil {
 		log.Fatalf("Failed to setup queues: %v", err)
 		return
 	}
+	defer notificationService.QueueClient.Close()
 
-	if err != nil {
-		log.Fatalf("Failed to initialize notification service: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	idempotencyRepository := db.NewIdempotencyRepository(pool)
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyRepository, durationFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour), durationFromEnv("IDEMPOTENCY_KEY_LEASE", time.Minute))
+	go idempotencyMiddleware.PurgeExpired(ctx, time.Hour)
+
+	notificationScheduler := scheduler.NewScheduler(scheduleRepository, durationFromEnv("SCHEDULER_POLL_INTERVAL", 5*time.Second))
+	go notificationScheduler.Run(ctx)
+
+	outboxRelay := outbox.NewRelay(outboxRepository, notificationService.QueueClient, durationFromEnv("OUTBOX_POLL_INTERVAL", time.Second), durationFromEnv("OUTBOX_RETENTION", 24*time.Hour), intFromEnv("OUTBOX_MAX_ATTEMPTS", 20))
+	notificationService.Relay = outboxRelay
+	go outboxRelay.Run(ctx)
+
+	apiKeyRepository := db.NewAPIKeyRepository(pool)
+	webhookRepository := db.NewWebhookRepository(pool)
+	preferenceRepository := db.NewPreferenceRepository(pool)
+	attachmentRepository := db.NewAttachmentRepository(pool)
+	attachmentRetention := durationFromEnv("ATTACHMENT_RETENTION", 30*24*time.Hour)
+	go purgeAttachments(ctx, attachmentRepository, time.Hour)
+	authenticator := auth.NewAuthenticator(apiKeyRepository)
+
+	perTypeLimits := map[common.NotificationType]ratelimit.Limit{}
+	for notificationType, name := range map[common.NotificationType]string{
+		common.EmailNotificationType: "RATE_LIMIT_EMAIL",
+		common.SmsNotificationType:   "RATE_LIMIT_SMS",
+	} {
+		if os.Getenv(name) != "" {
+			perTypeLimits[notificationType] = limitFromEnv(name, "")
+		}
 	}
+	limiter := ratelimit.NewLimiter(rateLimitStore(pool), db.NewRateLimitRepository(pool), limitFromEnv("RATE_LIMIT_DEFAULT", "50/s:100"), perTypeLimits)
+	go limiter.PurgeBuckets(ctx, 10*time.Minute)
 
-	http.HandleFunc("/v1/notification", notificationHandler(notificationService))
+	// Every /v1 route requires an API key, the scope depends on the route and method.
+	v1 := http.NewServeMux()
+	maxBatchSize := intFromEnv("MAX_BATCH_SIZE", 1000)
+	// The whole batch is held in memory, so its body gets a fixed cap rather
+	// than a multiple of the per-notification limit.
+	maxBatchBodySize := int64(intFromEnv("MAX_BATCH_BODY_SIZE", 64<<20))
+	v1.Handle("/v1/notification", metrics.InstrumentRequests(auth.RequireScope(auth.ScopeSend, limitBody(maxNotificationBodySize, idempotencyMiddleware.Wrap(notificationHandler(notificationService, limiter, templateRepository, webhookRepository, attachmentRepository, attachmentRetention))))))
+	v1.Handle("/v1/notifications/batch", metrics.InstrumentRequests(auth.RequireScope(auth.ScopeSend, limitBody(maxBatchBodySize, idempotencyMiddleware.Wrap(batchNotificationHandler(notificationService, limiter, templateRepository, webhookRepository, attachmentRepository, attachmentRetention, maxBatchSize))))))
+	v1.Handle("/v1/notifications/", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet:    auth.ScopeRead,
+		http.MethodDelete: auth.ScopeSend,
+	}, notificationByIdHandler(notificationRepository, scheduleRepository)))
+	v1.Handle("/v1/templates", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet: auth.ScopeRead,
+	}, templatesHandler(templateRepository)))
+	v1.Handle("/v1/templates/", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet: auth.ScopeRead,
+	}, templateByNameHandler(templateRepository)))
+	v1.Handle("/v1/users/", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet: auth.ScopeRead,
+		http.MethodPut: auth.ScopeSend,
+	}, userPreferencesHandler(preferenceRepository)))
+	v1.Handle("/v1/webhook", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet:    auth.ScopeRead,
+		http.MethodPut:    auth.ScopeSend,
+		http.MethodDelete: auth.ScopeSend,
+	}, webhookHandler(webhookRepository)))
+	v1.Handle("/v1/attachments", auth.RequireScope(auth.ScopeSend, attachmentsHandler(attachmentRepository, attachmentRetention)))
+	v1.Handle("/v1/attachments/", auth.RequireMethodScopes(map[string]string{
+		http.MethodGet:    auth.ScopeRead,
+		http.MethodDelete: auth.ScopeSend,
+	}, attachmentByIdHandler(attachmentRepository)))
+	v1.Handle("/v1/admin/api-keys", auth.RequireScope(auth.ScopeAdmin, apiKeysHandler(apiKeyRepository)))
+	v1.Handle("/v1/admin/api-keys/", auth.RequireScope(auth.ScopeAdmin, apiKeyByIdHandler(apiKeyRepository)))
+	http.Handle("/v1/", authenticator.Authenticate(v1))
 
+	notificationQueues := make([]string, len(common.Priorities))
+	for i, priority := range common.Priorities {
+		notificationQueues[i] = common.QueueNameForPriority(notificationQueueName, priority)
+	}
+	// The outbox holds accepted notifications while RabbitMQ is down, so the
+	// API stays ready as long as PostgreSQL is reachable.
+	readiness := health.NewChecker(5 * time.Second)
+	readiness.AddOptional("rabbitmq_connection", notificationService.QueueClient.CheckConnection)
+	readiness.AddOptional("rabbitmq_queues", func(ctx context.Context) error {
+		return notificationService.QueueClient.CheckQueues(ctx, notificationQueues)
+	})
+	readiness.Add("postgres", pool.Ping)
+
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/livez", health.LivenessHandler)
+	http.HandleFunc("/readyz", readiness.ReadinessHandler)
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
@@ -80,9 +450,6 @@ func main() {
 		}
 	}()
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
 	<-ctx.Done()
 	log.Println("Shutting down server...")
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type preferencesRequest struct {
+	PreferredChannel common.NotificationType                              `json:"preferredChannel"`
+	Categories       map[common.Category]map[common.NotificationType]bool `json:"categories"`
+	TimeZone         string                                               `json:"timeZone"`
+	Locale           string                                               `json:"locale"`
+	QuietHours       *common.QuietHours                                   `json:"quietHours"`
+}
+
+// userPreferencesHandler serves /v1/users/{id}/preferences. GET returns the
+// effective preferences; PUT replaces them, and categories or channels left
+// out are enabled, and leaving out timeZone means UTC.
+func userPreferencesHandler(preferenceRepo db.PreferenceRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/users/"), "/preferences")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := uuid.Parse(userID); err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		var preferences *db.UserPreferences
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			preferences, err = preferenceRepo.GetPreferences(r.Context(), userID)
+		case http.MethodPut:
+			var request preferencesRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				log.Printf("Invalid request body: %v", err)
+				writeProblem(w, r, http.StatusBadRequest, "Invalid request body", err.Error(), nil)
+				return
+			}
+			if errs := validatePreferences(request); errs != nil {
+				writeProblem(w, r, http.StatusBadRequest, "Invalid preferences", "The preferences failed validation.", errs)
+				return
+			}
+			preferences, err = preferenceRepo.SavePreferences(r.Context(), db.UserPreferences{
+				UserID:           userID,
+				PreferredChannel: request.PreferredChannel,
+				Categories:       request.Categories,
+				TimeZone:         request.TimeZone,
+				Locale:           request.Locale,
+				QuietHours:       request.QuietHours,
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error handling preferences for user %s: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, preferences)
+	}
+}
+
+func validatePreferences(request preferencesRequest) common.ValidationErrors {
+	var errs common.ValidationErrors
+	if request.PreferredChannel != "" && !common.IsValidType(request.PreferredChannel) {
+		errs = append(errs, common.FieldError{Field: "preferredChannel", Message: fmt.Sprintf("must be %q or %q", common.EmailNotificationType, common.SmsNotificationType)})
+	}
+	for category, channels := range request.Categories {
+		if category == "" || !common.IsValidCategory(category) {
+			errs = append(errs, common.FieldError{Field: "categories." + string(category), Message: fmt.Sprintf("must be %q or %q", common.TransactionalCategory, common.MarketingCategory)})
+			continue
+		}
+		for channel := range channels {
+			if !common.IsValidType(channel) {
+				errs = append(errs, common.FieldError{Field: "categories." + string(category) + "." + string(channel), Message: fmt.Sprintf("must be %q or %q", common.EmailNotificationType, common.SmsNotificationType)})
+			}
+		}
+	}
+	if request.TimeZone != "" && !common.IsValidTimeZone(request.TimeZone) {
+		errs = append(errs, common.FieldError{Field: "timeZone", Message: "must be an IANA time zone such as Europe/Sofia"})
+	}
+	if request.Locale != "" && !common.IsValidLocale(request.Locale) {
+		errs = append(errs, common.FieldError{Field: "locale", Message: "must be a locale such as bg or bg-BG"})
+	}
+	if request.QuietHours != nil {
+		if !common.IsValidTimeOfDay(request.QuietHours.Start) {
+			errs = append(errs, common.FieldError{Field: "quietHours.start", Message: "must be a time of day as HH:MM"})
+		}
+		if !common.IsValidTimeOfDay(request.QuietHours.End) {
+			errs = append(errs, common.FieldError{Field: "quietHours.end", Message: "must be a time of day as HH:MM"})
+		} else if request.QuietHours.End == request.QuietHours.Start {
+			errs = append(errs, common.FieldError{Field: "quietHours.end", Message: "must differ from start"})
+		}
+	}
+	return errs
+}
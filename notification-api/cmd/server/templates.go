@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,99}$`)
+
+type templateRequest struct {
+	Name      string                        `json:"name"`
+	Type      common.NotificationType       `json:"type"`
+	Subject   string                        `json:"subject"`
+	Body      string                        `json:"body"`
+	Localized map[string]db.TemplateVariant `json:"localized"`
+}
+
+// templatesHandler lists the latest version of every template on GET and
+// creates a new template on POST.
+func templatesHandler(templateRepo db.TemplateRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			templates, err := templateRepo.ListTemplates(r.Context())
+			if err != nil {
+				log.Printf("Error listing templates: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, templates)
+		case http.MethodPost:
+			request, ok := decodeTemplateRequest(w, r)
+			if !ok {
+				return
+			}
+			_, err := templateRepo.GetTemplate(r.Context(), request.Name, 0)
+			if err == nil {
+				http.Error(w, "Template already exists", http.StatusConflict)
+				return
+			}
+			if !errors.Is(err, db.ErrNotFound) {
+				log.Printf("Error fetching template %s: %v", request.Name, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			createTemplateVersion(w, r, templateRepo, request, http.StatusCreated)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// templateByNameHandler serves a single template. PUT stores a new version,
+// earlier versions stay available so queued notifications render as sent.
+func templateByNameHandler(templateRepo db.TemplateRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/v1/templates/")
+		if !templateNamePattern.MatchString(name) {
+			http.Error(w, "Invalid template name", http.StatusBadRequest)
+			return
+		}
+
+		version := 0
+		if value := r.URL.Query().Get("version"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				http.Error(w, "Invalid template version", http.StatusBadRequest)
+				return
+			}
+			version = parsed
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			template, err := templateRepo.GetTemplate(r.Context(), name, version)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Template not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error fetching template %s: %v", name, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, template)
+		case http.MethodPut:
+			request, ok := decodeTemplateRequest(w, r)
+			if !ok {
+				return
+			}
+			if request.Name != name {
+				http.Error(w, "Template name does not match the URL", http.StatusBadRequest)
+				return
+			}
+			createTemplateVersion(w, r, templateRepo, request, http.StatusOK)
+		case http.MethodDelete:
+			err := templateRepo.DeleteTemplate(r.Context(), name, version)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Template not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting template %s: %v", name, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func decodeTemplateRequest(w http.ResponseWriter, r *http.Request) (templateRequest, bool) {
+	var request templateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Invalid request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return request, false
+	}
+	if r.Method == http.MethodPut && request.Name == "" {
+		request.Name = strings.TrimPrefix(r.URL.Path, "/v1/templates/")
+	}
+
+	if !templateNamePattern.MatchString(request.Name) {
+		http.Error(w, "Invalid template name", http.StatusBadRequest)
+		return request, false
+	}
+	if !common.IsValidType(request.Type) {
+		http.Error(w, "Invalid notification type", http.StatusBadRequest)
+		return request, false
+	}
+	if request.Body == "" {
+		http.Error(w, "Template body is required", http.StatusBadRequest)
+		return request, false
+	}
+	if err := common.ParseTemplate(request.Type, request.Subject, request.Body); err != nil {
+		http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+		return request, false
+	}
+	for locale, variant := range request.Localized {
+		if !common.IsValidLocale(locale) {
+			http.Error(w, "Invalid template locale: "+locale, http.StatusBadRequest)
+			return request, false
+		}
+		if variant.Body == "" {
+			http.Error(w, "Template body is required for locale "+locale, http.StatusBadRequest)
+			return request, false
+		}
+		if err := common.ParseTemplate(request.Type, variant.Subject, variant.Body); err != nil {
+			http.Error(w, "Invalid template for locale "+locale+": "+err.Error(), http.StatusBadRequest)
+			return request, false
+		}
+	}
+	return request, true
+}
+
+func createTemplateVersion(w http.ResponseWriter, r *http.Request, templateRepo db.TemplateRepository, request templateRequest, status int) {
+	template, err := templateRepo.CreateTemplateVersion(r.Context(), db.Template{
+		Name:      request.Name,
+		Type:      request.Type,
+		Subject:   request.Subject,
+		Body:      request.Body,
+		Localized: request.Localized,
+	})
+	if errors.Is(err, db.ErrConflict) {
+		http.Error(w, "Template was modified concurrently, please retry", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing template %s: %v", request.Name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, template)
+}
+
+// templateRef is a template name and version as a notification references it.
+type templateRef struct {
+	name    string
+	version int
+}
+
+// pinTemplate resolves the template a notification references and pins its
+// version, so a template updated while the notification waits in the queue
+// does not change what is sent. Templates already looked up are taken from
+// cache when it is not nil.
+func pinTemplate(ctx context.Context, templateRepo db.TemplateRepository, cache map[templateRef]*db.Template, notification *common.Notification) (common.ValidationErrors, error) {
+	if notification.TemplateID == "" {
+		return nil, nil
+	}
+
+	ref := templateRef{name: notification.TemplateID, version: notification.TemplateVersion}
+	template, ok := cache[ref]
+	if !ok {
+		var err error
+		template, err = templateRepo.GetTemplate(ctx, ref.name, ref.version)
+		if errors.Is(err, db.ErrNotFound) {
+			template = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if cache != nil {
+			cache[ref] = template
+		}
+	}
+
+	switch {
+	case template == nil && ref.version > 0:
+		return common.ValidationErrors{{Field: "templateVersion", Message: fmt.Sprintf("template %s has no version %d", ref.name, ref.version)}}, nil
+	case template == nil:
+		return common.ValidationErrors{{Field: "templateId", Message: fmt.Sprintf("template %s does not exist", ref.name)}}, nil
+	case template.Type != notification.Type:
+		return common.ValidationErrors{{Field: "templateId", Message: fmt.Sprintf("template %s is for %s notifications", ref.name, template.Type)}}, nil
+	}
+	notification.TemplateVersion = template.Version
+	return nil, nil
+}
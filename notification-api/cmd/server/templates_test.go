@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+// fakeTemplateRepo serves version 1 and 2 of the "welcome" email template.
+type fakeTemplateRepo struct {
+	db.TemplateRepository
+	lookups int
+}
+
+func (repo *fakeTemplateRepo) GetTemplate(ctx context.Context, name string, version int) (*db.Template, error) {
+	repo.lookups++
+	if name != "welcome" || version > 2 {
+		return nil, db.ErrNotFound
+	}
+	if version == 0 {
+		version = 2
+	}
+	return &db.Template{Name: name, Version: version, Type: common.EmailNotificationType}, nil
+}
+
+func TestPinTemplate(t *testing.T) {
+	tests := []struct {
+		name         string
+		notification common.Notification
+		version      int
+		field        string
+	}{
+		{"no template", common.Notification{Type: common.EmailNotificationType}, 0, ""},
+		{"latest version", common.Notification{Type: common.EmailNotificationType, TemplateID: "welcome"}, 2, ""},
+		{"given version", common.Notification{Type: common.EmailNotificationType, TemplateID: "welcome", TemplateVersion: 1}, 1, ""},
+		{"unknown template", common.Notification{Type: common.EmailNotificationType, TemplateID: "goodbye"}, 0, "templateId"},
+		{"unknown version", common.Notification{Type: common.EmailNotificationType, TemplateID: "welcome", TemplateVersion: 3}, 3, "templateVersion"},
+		{"other type", common.Notification{Type: common.SmsNotificationType, TemplateID: "welcome"}, 0, "templateId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := tt.notification
+			errs, err := pinTemplate(context.Background(), &fakeTemplateRepo{}, nil, &notification)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			switch {
+			case tt.field == "" && errs != nil:
+				t.Fatalf("unexpected validation errors: %v", errs)
+			case tt.field != "" && (len(errs) != 1 || errs[0].Field != tt.field):
+				t.Fatalf("errors = %v, want one for %s", errs, tt.field)
+			}
+			if notification.TemplateVersion != tt.version {
+				t.Errorf("templateVersion = %d, want %d", notification.TemplateVersion, tt.version)
+			}
+		})
+	}
+}
+
+func TestPinTemplateCache(t *testing.T) {
+	repo := &fakeTemplateRepo{}
+	cache := make(map[templateRef]*db.Template)
+	for _, templateID := range []string{"welcome", "welcome", "goodbye", "goodbye"} {
+		notification := common.Notification{Type: common.EmailNotificationType, TemplateID: templateID}
+		if _, err := pinTemplate(context.Background(), repo, cache, &notification); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if repo.lookups != 2 {
+		t.Errorf("looked up %d templates, want 2", repo.lookups)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type webhookRequest struct {
+	URL string `json:"url"`
+}
+
+// webhookHandler manages the webhook subscription of the calling API key.
+// PUT creates it, or changes its URL, and returns the signing secret; GET
+// returns it without the secret; DELETE removes it.
+func webhookHandler(webhookRepo db.WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			subscription, err := webhookRepo.GetWebhookSubscription(r.Context(), identity.KeyID)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error fetching webhook subscription: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, subscription)
+		case http.MethodPut:
+			var request webhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				log.Printf("Invalid request body: %v", err)
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if request.URL != "" && (len(request.URL) > common.MaxCallbackURLLength || !common.IsValidCallbackURL(request.URL)) {
+				http.Error(w, "Webhook url must be an absolute http or https URL to a public host", http.StatusBadRequest)
+				return
+			}
+
+			// Only used when the subscription is new; an existing one keeps
+			// its secret.
+			secret, err := auth.NewWebhookSecret()
+			if err != nil {
+				log.Printf("Error generating webhook secret: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			subscription, err := webhookRepo.SaveWebhookSubscription(r.Context(), identity.KeyID, request.URL, secret)
+			if err != nil {
+				log.Printf("Error saving webhook subscription: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, subscription)
+		case http.MethodDelete:
+			err := webhookRepo.DeleteWebhookSubscription(r.Context(), identity.KeyID)
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error deleting webhook subscription: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// callbackURLError is the validation error for a callback URL sent by a key
+// without a webhook subscription, whose secret is needed to sign the events.
+var callbackURLError = common.FieldError{Field: "callbackUrl", Message: "requires a webhook subscription, create one with PUT /v1/webhook"}
+
+func hasWebhookSubscription(ctx context.Context, webhookRepo db.WebhookRepository, apiKeyID string) (bool, error) {
+	_, err := webhookRepo.GetWebhookSubscription(ctx, apiKeyID)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// apiKeyIDFromRequest returns the ID of the key that authenticated r, which
+// the worker uses to find the key's webhook subscription.
+func apiKeyIDFromRequest(r *http.Request) string {
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok {
+		return identity.KeyID
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+const (
+	// ScopeSend allows submitting and cancelling notifications.
+	ScopeSend = "send"
+	// ScopeRead allows reading notification statuses and templates.
+	ScopeRead = "read"
+	// ScopeAdmin allows everything, including template and API key management.
+	ScopeAdmin = "admin"
+
+	keyPrefix           = "nsk_"
+	webhookSecretPrefix = "whsec_"
+	displayedLength     = 12
+)
+
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeSend, ScopeRead, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// HashKey returns the hex SHA-256 of a key. Keys carry 256 random bits, so a
+// plain hash is enough to keep them unusable if the table leaks.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueKey creates and stores a new API key. The plaintext key is returned
+// only here and cannot be recovered later.
+func IssueKey(ctx context.Context, repo db.APIKeyRepository, name string, scopes []string) (string, *db.APIKey, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("API key name must not be empty")
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("API key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return "", nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("error generating API key: %w", err)
+	}
+	plaintext := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key, err := repo.CreateAPIKey(ctx, db.APIKey{
+		ID:     uuid.NewString(),
+		Name:   name,
+		Prefix: plaintext[:displayedLength],
+		Scopes: scopes,
+	}, HashKey(plaintext))
+	if err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// NewWebhookSecret returns a random secret for signing webhook events.
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type contextKey struct{}
+
+// Identity is the caller behind an authenticated request.
+type Identity struct {
+	KeyID  string
+	Name   string
+	Scopes []string
+}
+
+// HasScope reports whether the caller holds the scope. Admin holds every scope.
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok
+}
+
+type Authenticator struct {
+	Repo db.APIKeyRepository
+}
+
+func NewAuthenticator(repo db.APIKeyRepository) *Authenticator {
+	return &Authenticator{Repo: repo}
+}
+
+// Authenticate rejects requests without a valid API key, sent either as
+// "Authorization: Bearer <key>" or in the X-API-Key header.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := keyFromRequest(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="notification-api"`)
+			http.Error(w, "Missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		apiKey, err := a.Repo.GetActiveAPIKeyByHash(r.Context(), HashKey(key))
+		if errors.Is(err, db.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="notification-api", error="invalid_token"`)
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating request: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		identity := &Identity{KeyID: apiKey.ID, Name: apiKey.Name, Scopes: apiKey.Scopes}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, identity)))
+	})
+}
+
+// RequireScope lets the request through only when the caller holds scope.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok || !identity.HasScope(scope) {
+			http.Error(w, "API key is missing the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMethodScopes picks the required scope by HTTP method, for routes
+// that mix reads and writes on the same path.
+func RequireMethodScopes(scopes map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := scopes[r.Method]
+		if !ok {
+			scope = ScopeAdmin
+		}
+		RequireScope(scope, next).ServeHTTP(w, r)
+	})
+}
+
+func keyFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
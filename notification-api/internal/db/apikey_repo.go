@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error)
+	// GetActiveAPIKeyByHash returns a key that has not been revoked and marks
+	// it as used. The mark is refreshed at most once a minute, so busy keys
+	// do not cost a write per request.
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+type PgxAPIKeyRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(pool *pgxpool.Pool) *PgxAPIKeyRepository {
+	return &PgxAPIKeyRepository{Pool: pool}
+}
+
+func (repo *PgxAPIKeyRepository) CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error) {
+	const insertKeySQL = `
+        INSERT INTO api_keys (id, name, prefix, key_hash, scopes)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING created_at;
+    `
+
+	err := repo.Pool.QueryRow(ctx, insertKeySQL, key.ID, key.Name, key.Prefix, keyHash, key.Scopes).Scan(&key.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting API key: %w", err)
+	}
+	return &key, nil
+}
+
+func (repo *PgxAPIKeyRepository) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	const touchKeySQL = `
+        WITH found AS (
+            SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM api_keys
+            WHERE key_hash = $1 AND revoked_at IS NULL
+        ), touched AS (
+            UPDATE api_keys SET last_used_at = NOW()
+            FROM found
+            WHERE api_keys.id = found.id
+              AND (found.last_used_at IS NULL OR found.last_used_at < NOW() - INTERVAL '1 minute')
+        )
+        SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM found;
+    `
+
+	var key APIKey
+	err := repo.Pool.QueryRow(ctx, touchKeySQL, keyHash).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Scopes,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying API key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func (repo *PgxAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	const listKeysSQL = `
+        SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at
+        FROM api_keys ORDER BY created_at;
+    `
+
+	rows, err := repo.Pool.Query(ctx, listKeysSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Name,
+			&key.Prefix,
+			&key.Scopes,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (repo *PgxAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	const revokeKeySQL = `
+        UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, revokeKeySQL, id)
+	if err != nil {
+		return fmt.Errorf("error revoking API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Attachment describes a stored file. The content is only read by the worker.
+type Attachment struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type AttachmentRepository interface {
+	// CreateAttachment stores the file for the API key and keeps it until at
+	// least expiresAt. Storing the same file again returns the existing one.
+	CreateAttachment(ctx context.Context, apiKeyID string, filename string, contentType string, content []byte, expiresAt time.Time) (*Attachment, error)
+	// KeepAttachment returns the API key's attachment and keeps it until at
+	// least expiresAt.
+	KeepAttachment(ctx context.Context, apiKeyID string, id string, expiresAt time.Time) (*Attachment, error)
+	GetAttachment(ctx context.Context, apiKeyID string, id string) (*Attachment, error)
+	DeleteAttachment(ctx context.Context, apiKeyID string, id string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type PgxAttachmentRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewAttachmentRepository(pool *pgxpool.Pool) *PgxAttachmentRepository {
+	return &PgxAttachmentRepository{Pool: pool}
+}
+
+func (repo *PgxAttachmentRepository) CreateAttachment(ctx context.Context, apiKeyID string, filename string, contentType string, content []byte, expiresAt time.Time) (*Attachment, error) {
+	const insertAttachmentSQL = `
+        INSERT INTO attachments (id, api_key_id, filename, content_type, size, content, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (id) DO UPDATE
+        SET expires_at = GREATEST(attachments.expires_at, EXCLUDED.expires_at)
+        RETURNING id, filename, content_type, size, created_at, expires_at;
+    `
+
+	contentHash := sha256.Sum256(content)
+	name := fmt.Sprintf("attachment:%s/%s/%s/%s", apiKeyID, filename, contentType, hex.EncodeToString(contentHash[:]))
+	id := uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+
+	var attachment Attachment
+	err := repo.Pool.QueryRow(ctx, insertAttachmentSQL, id, apiKeyID, filename, contentType, len(content), content, expiresAt).Scan(
+		&attachment.ID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&attachment.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+func (repo *PgxAttachmentRepository) KeepAttachment(ctx context.Context, apiKeyID string, id string, expiresAt time.Time) (*Attachment, error) {
+	const keepAttachmentSQL = `
+        UPDATE attachments SET expires_at = GREATEST(expires_at, $3)
+        WHERE id = $1 AND api_key_id = $2 AND expires_at > NOW()
+        RETURNING id, filename, content_type, size, created_at, expires_at;
+    `
+
+	return repo.queryAttachment(ctx, keepAttachmentSQL, id, apiKeyID, expiresAt)
+}
+
+func (repo *PgxAttachmentRepository) GetAttachment(ctx context.Context, apiKeyID string, id string) (*Attachment, error) {
+	const getAttachmentSQL = `
+        SELECT id, filename, content_type, size, created_at, expires_at FROM attachments
+        WHERE id = $1 AND api_key_id = $2 AND expires_at > NOW();
+    `
+
+	return repo.queryAttachment(ctx, getAttachmentSQL, id, apiKeyID)
+}
+
+func (repo *PgxAttachmentRepository) queryAttachment(ctx context.Context, sql string, args ...interface{}) (*Attachment, error) {
+	var attachment Attachment
+	err := repo.Pool.QueryRow(ctx, sql, args...).Scan(
+		&attachment.ID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&attachment.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+func (repo *PgxAttachmentRepository) DeleteAttachment(ctx context.Context, apiKeyID string, id string) error {
+	const deleteAttachmentSQL = `
+        DELETE FROM attachments WHERE id = $1 AND api_key_id = $2;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteAttachmentSQL, id, apiKeyID)
+	if err != nil {
+		return fmt.Errorf("error deleting attachment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PgxAttachmentRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	const deleteExpiredAttachmentsSQL = `
+        DELETE FROM attachments WHERE expires_at < NOW();
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteExpiredAttachmentsSQL)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired attachments: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
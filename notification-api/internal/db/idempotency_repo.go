@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	// ReservationID identifies the request holding the key, so one that
+	// outlived its lease cannot complete a key another request took over.
+	ReservationID string
+	StatusCode    int
+	ContentType   string
+	ResponseBody  []byte
+}
+
+// Completed reports whether the original request finished and its response was stored.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+type IdempotencyRepository interface {
+	// Reserve claims the key for a new request until lease runs out. When the
+	// key is already taken and not expired, it returns the existing record
+	// and false; a reservation whose lease ran out is taken over.
+	Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*IdempotencyRecord, bool, error)
+	// Complete stores the response of the reservation and keeps it for ttl.
+	Complete(ctx context.Context, record *IdempotencyRecord, statusCode int, contentType string, body []byte, ttl time.Duration) error
+	Release(ctx context.Context, record *IdempotencyRecord) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type PgxIdempotencyRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(pool *pgxpool.Pool) *PgxIdempotencyRepository {
+	return &PgxIdempotencyRepository{Pool: pool}
+}
+
+func (repo *PgxIdempotencyRepository) Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*IdempotencyRecord, bool, error) {
+	const reserveKeySQL = `
+        INSERT INTO idempotency_keys (key, request_hash, reservation_id, expires_at)
+        VALUES ($1, $2, $4, NOW() + make_interval(secs => $3))
+        ON CONFLICT (key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            reservation_id = EXCLUDED.reservation_id,
+            status_code = NULL,
+            content_type = NULL,
+            response_body = NULL,
+            created_at = NOW(),
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at < NOW()
+        RETURNING key;
+    `
+
+	reservationID := uuid.NewString()
+	var reserved string
+	err := repo.Pool.QueryRow(ctx, reserveKeySQL, key, requestHash, lease.Seconds(), reservationID).Scan(&reserved)
+	if err == nil {
+		return &IdempotencyRecord{Key: key, RequestHash: requestHash, ReservationID: reservationID}, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("error reserving idempotency key: %w", err)
+	}
+
+	const getKeySQL = `
+        SELECT key, request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), response_body
+        FROM idempotency_keys WHERE key = $1;
+    `
+
+	var record IdempotencyRecord
+	err = repo.Pool.QueryRow(ctx, getKeySQL, key).Scan(
+		&record.Key,
+		&record.RequestHash,
+		&record.StatusCode,
+		&record.ContentType,
+		&record.ResponseBody,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying idempotency key: %w", err)
+	}
+
+	return &record, false, nil
+}
+
+func (repo *PgxIdempotencyRepository) Complete(ctx context.Context, record *IdempotencyRecord, statusCode int, contentType string, body []byte, ttl time.Duration) error {
+	const completeKeySQL = `
+        UPDATE idempotency_keys
+        SET status_code = $3, content_type = $4, response_body = $5, expires_at = NOW() + make_interval(secs => $6)
+        WHERE key = $1 AND reservation_id = $2 AND status_code IS NULL;
+    `
+
+	if _, err := repo.Pool.Exec(ctx, completeKeySQL, record.Key, record.ReservationID, statusCode, contentType, body, ttl.Seconds()); err != nil {
+		return fmt.Errorf("error storing idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxIdempotencyRepository) Release(ctx context.Context, record *IdempotencyRecord) error {
+	const releaseKeySQL = `
+        DELETE FROM idempotency_keys WHERE key = $1 AND reservation_id = $2 AND status_code IS NULL;
+    `
+
+	if _, err := repo.Pool.Exec(ctx, releaseKeySQL, record.Key, record.ReservationID); err != nil {
+		return fmt.Errorf("error releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxIdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	const deleteExpiredSQL = `
+        DELETE FROM idempotency_keys WHERE expires_at < NOW();
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
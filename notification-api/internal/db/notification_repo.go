@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+type NotificationRecord struct {
+	ID                string                    `json:"id"`
+	Type              common.NotificationType   `json:"type"`
+	Status            common.NotificationStatus `json:"status"`
+	RetryCount        int                       `json:"retryCount"`
+	LastError         string                    `json:"lastError,omitempty"`
+	SkippedRecipients []SkippedRecipient        `json:"skippedRecipients,omitempty"`
+	CreatedAt         time.Time                 `json:"createdAt"`
+	UpdatedAt         time.Time                 `json:"updatedAt"`
+}
+
+// SkippedRecipient is a user the worker did not send to, with the reason.
+// ReroutedTo is the notification that reaches the user on another channel.
+type SkippedRecipient struct {
+	UserID     string `json:"userId"`
+	Reason     string `json:"reason"`
+	ReroutedTo string `json:"reroutedTo,omitempty"`
+}
+
+type NotificationRepository interface {
+	CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) error
+	UpdateNotificationStatus(ctx context.Context, id string, status common.NotificationStatus, lastError string) error
+	// GetNotificationById returns a notification submitted with the given API key.
+	GetNotificationById(ctx context.Context, apiKeyID string, id string) (*NotificationRecord, error)
+}
+
+type PgxNotificationRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewNotificationRepository(pool *pgxpool.Pool) *PgxNotificationRepository {
+	return &PgxNotificationRepository{Pool: pool}
+}
+
+func (repo *PgxNotificationRepository) CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) error {
+	const insertNotificationSQL = `
+        INSERT INTO notifications (id, type, status, api_key_id) VALUES ($1, $2, $3, $4);
+    `
+
+	if _, err := repo.Pool.Exec(ctx, insertNotificationSQL, notification.ID, notification.Type, status, notification.APIKeyID); err != nil {
+		return fmt.Errorf("error inserting notification: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxNotificationRepository) UpdateNotificationStatus(ctx context.Context, id string, status common.NotificationStatus, lastError string) error {
+	const updateStatusSQL = `
+        UPDATE notifications
+        SET status = $2, last_error = NULLIF($3, ''), updated_at = NOW()
+        WHERE id = $1;
+    `
+
+	if _, err := repo.Pool.Exec(ctx, updateStatusSQL, id, status, lastError); err != nil {
+		return fmt.Errorf("error updating notification status: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxNotificationRepository) GetNotificationById(ctx context.Context, apiKeyID string, id string) (*NotificationRecord, error) {
+	const getNotificationSQL = `
+        SELECT id, type, status, retry_count, COALESCE(last_error, ''), created_at, updated_at
+        FROM notifications WHERE id = $1 AND api_key_id = $2;
+    `
+
+	var record NotificationRecord
+	err := repo.Pool.QueryRow(ctx, getNotificationSQL, id, apiKeyID).Scan(
+		&record.ID,
+		&record.Type,
+		&record.Status,
+		&record.RetryCount,
+		&record.LastError,
+		&record.CreatedAt,
+		&record.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying notification: %w", err)
+	}
+
+	record.SkippedRecipients, err = repo.getSkippedRecipients(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (repo *PgxNotificationRepository) getSkippedRecipients(ctx context.Context, id string) ([]SkippedRecipient, error) {
+	const getSkippedSQL = `
+        SELECT user_id, reason, COALESCE(rerouted_to::text, '') FROM notification_skipped_recipients
+        WHERE notification_id = $1
+        ORDER BY created_at, user_id;
+    `
+
+	rows, err := repo.Pool.Query(ctx, getSkippedSQL, id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying skipped recipients: %w", err)
+	}
+	defer rows.Close()
+
+	var skipped []SkippedRecipient
+	for rows.Next() {
+		var recipient SkippedRecipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Reason, &recipient.ReroutedTo); err != nil {
+			return nil, fmt.Errorf("error scanning skipped recipient: %w", err)
+		}
+		skipped = append(skipped, recipient)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return skipped, nil
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+// OutboxEntry is a notification together with the message to publish for it.
+type OutboxEntry struct {
+	Notification common.Notification
+	Queue        string
+	Message      []byte
+}
+
+type OutboxMessage struct {
+	ID             int64
+	NotificationID string
+	Queue          string
+	Message        []byte
+	Attempts       int
+}
+
+// outboxClaimLease is how long claimed messages are hidden from other relays
+// while they are published.
+const outboxClaimLease = time.Minute
+
+type OutboxRepository interface {
+	// Enqueue records the notifications as queued and adds their messages to
+	// the outbox in one transaction.
+	Enqueue(ctx context.Context, entries []OutboxEntry) error
+	// PublishPending claims up to limit pending messages, oldest first, and
+	// hands them to publish outside any transaction. Published messages are
+	// marked as sent and failed ones are retried later with exponential
+	// backoff, until maxAttempts attempts failed and the message and its
+	// notification are marked as failed.
+	PublishPending(ctx context.Context, limit int, maxAttempts int, publish func(messages []OutboxMessage) []error) (int, error)
+	// DeleteSent removes messages that were sent, or given up on, before the
+	// given time.
+	DeleteSent(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PgxOutboxRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewOutboxRepository(pool *pgxpool.Pool) *PgxOutboxRepository {
+	return &PgxOutboxRepository{Pool: pool}
+}
+
+func (repo *PgxOutboxRepository) Enqueue(ctx context.Context, entries []OutboxEntry) error {
+	const insertNotificationSQL = `
+        INSERT INTO notifications (id, type, status, api_key_id) VALUES ($1, $2, $3, $4);
+    `
+	const insertOutboxSQL = `
+        INSERT INTO outbox (notification_id, queue_name, message) VALUES ($1, $2, $3);
+    `
+
+	batch := &pgx.Batch{}
+	for _, entry := range entries {
+		batch.Queue(insertNotificationSQL, entry.Notification.ID, entry.Notification.Type, common.QueuedStatus, entry.Notification.APIKeyID)
+		batch.Queue(insertOutboxSQL, entry.Notification.ID, entry.Queue, entry.Message)
+	}
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error inserting outbox messages: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing outbox messages: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxOutboxRepository) PublishPending(ctx context.Context, limit int, maxAttempts int, publish func(messages []OutboxMessage) []error) (int, error) {
+	// Claiming pushes next_attempt_at past the lease, so other API replicas
+	// skip the messages while they are published without holding row locks.
+	// Messages of a replica that died while publishing are claimed again
+	// once the lease ran out.
+	const claimPendingSQL = `
+        WITH claimed AS (
+            UPDATE outbox SET next_attempt_at = NOW() + make_interval(secs => $2)
+            WHERE id IN (
+                SELECT id FROM outbox
+                WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+                ORDER BY created_at, id
+                LIMIT $1
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING id, notification_id, queue_name, message, attempts, created_at
+        )
+        SELECT id, notification_id, queue_name, message, attempts FROM claimed
+        ORDER BY created_at, id;
+    `
+	const markSentSQL = `
+        UPDATE outbox SET sent_at = NOW(), attempts = attempts + 1, last_error = NULL WHERE id = $1;
+    `
+	// Back off 1s, 2s, 4s, ... up to 5 minutes between attempts.
+	const markFailedSQL = `
+        UPDATE outbox
+        SET attempts = attempts + 1,
+            last_error = $2,
+            next_attempt_at = NOW() + make_interval(secs => LEAST(POWER(2, attempts), 300))
+        WHERE id = $1;
+    `
+	const markGivenUpSQL = `
+        UPDATE outbox SET attempts = attempts + 1, last_error = $2, failed_at = NOW() WHERE id = $1;
+    `
+	const failNotificationSQL = `
+        UPDATE notifications SET status = $2, last_error = $3, updated_at = NOW() WHERE id = $1;
+    `
+
+	rows, err := repo.Pool.Query(ctx, claimPendingSQL, limit, outboxClaimLease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("error claiming outbox messages: %w", err)
+	}
+	var pending []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		if err := rows.Scan(&message.ID, &message.NotificationID, &message.Queue, &message.Message, &message.Attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning outbox message: %w", err)
+		}
+		pending = append(pending, message)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating rows: %w", err)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	published := 0
+	batch := &pgx.Batch{}
+	for i, err := range publish(pending) {
+		message := pending[i]
+		switch {
+		case err == nil:
+			batch.Queue(markSentSQL, message.ID)
+			published++
+		case message.Attempts+1 >= maxAttempts:
+			lastError := fmt.Sprintf("giving up after %d attempts to queue the notification: %v", message.Attempts+1, err)
+			batch.Queue(markGivenUpSQL, message.ID, lastError)
+			batch.Queue(failNotificationSQL, message.NotificationID, common.FailedStatus, lastError)
+		default:
+			batch.Queue(markFailedSQL, message.ID, err.Error())
+		}
+	}
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("error updating outbox messages: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing outbox messages: %w", err)
+	}
+	return published, nil
+}
+
+func (repo *PgxOutboxRepository) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	const deleteSentSQL = `
+        DELETE FROM outbox WHERE sent_at < $1 OR failed_at < $1;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteSentSQL, before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting sent outbox messages: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+// UserPreferences says which channels a user wants per category and when
+// they may be notified. Every category and channel is present; channels
+// without a stored choice are enabled.
+type UserPreferences struct {
+	UserID           string                                               `json:"userId"`
+	PreferredChannel common.NotificationType                              `json:"preferredChannel,omitempty"`
+	Categories       map[common.Category]map[common.NotificationType]bool `json:"categories"`
+	TimeZone         string                                               `json:"timeZone"`
+	Locale           string                                               `json:"locale,omitempty"`
+	QuietHours       *common.QuietHours                                   `json:"quietHours,omitempty"`
+	UpdatedAt        *time.Time                                           `json:"updatedAt,omitempty"`
+}
+
+type PreferenceRepository interface {
+	// GetPreferences returns ErrNotFound when the user does not exist.
+	GetPreferences(ctx context.Context, userID string) (*UserPreferences, error)
+	// SavePreferences replaces the user's preferences. It returns ErrNotFound
+	// when the user does not exist.
+	SavePreferences(ctx context.Context, preferences UserPreferences) (*UserPreferences, error)
+}
+
+type PgxPreferenceRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewPreferenceRepository(pool *pgxpool.Pool) *PgxPreferenceRepository {
+	return &PgxPreferenceRepository{Pool: pool}
+}
+
+func (repo *PgxPreferenceRepository) GetPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	const getPreferencesSQL = `
+        SELECT COALESCE(p.preferred_channel, ''), u.time_zone, COALESCE(u.locale, ''),
+            COALESCE(to_char(u.quiet_hours_start, 'HH24:MI'), ''),
+            COALESCE(to_char(u.quiet_hours_end, 'HH24:MI'), ''),
+            p.updated_at
+        FROM users u LEFT JOIN user_preferences p ON p.user_id = u.id
+        WHERE u.id = $1;
+    `
+	const getCategoryPreferencesSQL = `
+        SELECT category, channel, enabled FROM user_category_preferences WHERE user_id = $1;
+    `
+
+	preferences := defaultPreferences(userID)
+	var quietHours common.QuietHours
+	err := repo.Pool.QueryRow(ctx, getPreferencesSQL, userID).Scan(&preferences.PreferredChannel, &preferences.TimeZone, &preferences.Locale, &quietHours.Start, &quietHours.End, &preferences.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying preferences: %w", err)
+	}
+	if quietHours.Start != "" && quietHours.End != "" {
+		preferences.QuietHours = &quietHours
+	}
+
+	rows, err := repo.Pool.Query(ctx, getCategoryPreferencesSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying category preferences: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category common.Category
+		var channel common.NotificationType
+		var enabled bool
+		if err := rows.Scan(&category, &channel, &enabled); err != nil {
+			return nil, fmt.Errorf("error scanning category preference: %w", err)
+		}
+		if channels, ok := preferences.Categories[category]; ok {
+			channels[channel] = enabled
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return preferences, nil
+}
+
+func (repo *PgxPreferenceRepository) SavePreferences(ctx context.Context, preferences UserPreferences) (*UserPreferences, error) {
+	const userExistsSQL = `
+        SELECT EXISTS (SELECT 1 FROM users WHERE id = $1);
+    `
+	const upsertPreferencesSQL = `
+        INSERT INTO user_preferences (user_id, preferred_channel) VALUES ($1, NULLIF($2, ''))
+        ON CONFLICT (user_id) DO UPDATE
+        SET preferred_channel = EXCLUDED.preferred_channel, updated_at = NOW();
+    `
+	const updateUserSQL = `
+        UPDATE users
+        SET time_zone = $2, quiet_hours_start = NULLIF($3, '')::time, quiet_hours_end = NULLIF($4, '')::time,
+            locale = NULLIF($5, '')
+        WHERE id = $1;
+    `
+	const deleteCategoryPreferencesSQL = `
+        DELETE FROM user_category_preferences WHERE user_id = $1;
+    `
+	const insertCategoryPreferenceSQL = `
+        INSERT INTO user_category_preferences (user_id, category, channel, enabled) VALUES ($1, $2, $3, $4);
+    `
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, userExistsSQL, preferences.UserID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error querying user: %w", err)
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var quietHours common.QuietHours
+	if preferences.QuietHours != nil {
+		quietHours = *preferences.QuietHours
+	}
+	timeZone := preferences.TimeZone
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(upsertPreferencesSQL, preferences.UserID, preferences.PreferredChannel)
+	batch.Queue(updateUserSQL, preferences.UserID, timeZone, quietHours.Start, quietHours.End, preferences.Locale)
+	batch.Queue(deleteCategoryPreferencesSQL, preferences.UserID)
+	for category, channels := range preferences.Categories {
+		for channel, enabled := range channels {
+			batch.Queue(insertCategoryPreferenceSQL, preferences.UserID, category, channel, enabled)
+		}
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return nil, fmt.Errorf("error saving preferences: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing preferences: %w", err)
+	}
+
+	return repo.GetPreferences(ctx, preferences.UserID)
+}
+
+func defaultPreferences(userID string) *UserPreferences {
+	preferences := &UserPreferences{
+		UserID:     userID,
+		Categories: make(map[common.Category]map[common.NotificationType]bool, len(common.Categories)),
+	}
+	for _, category := range common.Categories {
+		channels := make(map[common.NotificationType]bool, len(common.NotificationTypes))
+		for _, channel := range common.NotificationTypes {
+			channels[channel] = true
+		}
+		preferences.Categories[category] = channels
+	}
+	return preferences
+}
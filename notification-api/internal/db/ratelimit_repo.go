@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+type RateLimitOverride struct {
+	APIKeyID         string
+	NotificationType string
+	RatePerSecond    float64
+	Burst            int
+}
+
+type RateLimitRepository interface {
+	// GetRateLimit returns the override for the key and notification type,
+	// falling back to the key's override for every type ("*").
+	GetRateLimit(ctx context.Context, apiKeyID string, notificationType common.NotificationType) (*RateLimitOverride, error)
+}
+
+type PgxRateLimitRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewRateLimitRepository(pool *pgxpool.Pool) *PgxRateLimitRepository {
+	return &PgxRateLimitRepository{Pool: pool}
+}
+
+func (repo *PgxRateLimitRepository) GetRateLimit(ctx context.Context, apiKeyID string, notificationType common.NotificationType) (*RateLimitOverride, error) {
+	const getRateLimitSQL = `
+        SELECT api_key_id, notification_type, rate_per_second, burst FROM rate_limits
+        WHERE api_key_id = $1 AND notification_type IN ($2, '*')
+        ORDER BY notification_type = '*'
+        LIMIT 1;
+    `
+
+	var override RateLimitOverride
+	err := repo.Pool.QueryRow(ctx, getRateLimitSQL, apiKeyID, string(notificationType)).Scan(
+		&override.APIKeyID,
+		&override.NotificationType,
+		&override.RatePerSecond,
+		&override.Burst,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying rate limit: %w", err)
+	}
+
+	return &override, nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+var ErrNotScheduled = errors.New("notification is not scheduled")
+
+type ScheduleRepository interface {
+	Schedule(ctx context.Context, notification common.Notification, queueName string, message []byte) error
+	// Cancel removes a notification submitted with the given API key from
+	// the schedule.
+	Cancel(ctx context.Context, apiKeyID string, id string) error
+	// QueueDue moves up to limit due messages to the outbox, which publishes
+	// them, and marks their notifications as queued.
+	QueueDue(ctx context.Context, limit int) (int, error)
+}
+
+type PgxScheduleRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewScheduleRepository(pool *pgxpool.Pool) *PgxScheduleRepository {
+	return &PgxScheduleRepository{Pool: pool}
+}
+
+func (repo *PgxScheduleRepository) Schedule(ctx context.Context, notification common.Notification, queueName string, message []byte) error {
+	const insertNotificationSQL = `
+        INSERT INTO notifications (id, type, status, api_key_id) VALUES ($1, $2, $3, $4);
+    `
+	const insertScheduledSQL = `
+        INSERT INTO scheduled_notifications (id, send_at, queue_name, message) VALUES ($1, $2, $3, $4);
+    `
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, insertNotificationSQL, notification.ID, notification.Type, common.ScheduledStatus, notification.APIKeyID); err != nil {
+		return fmt.Errorf("error inserting notification: %w", err)
+	}
+	if _, err := tx.Exec(ctx, insertScheduledSQL, notification.ID, notification.SendAt, queueName, message); err != nil {
+		return fmt.Errorf("error inserting scheduled notification: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing scheduled notification: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxScheduleRepository) Cancel(ctx context.Context, apiKeyID string, id string) error {
+	const deleteScheduledSQL = `
+        DELETE FROM scheduled_notifications
+        USING notifications
+        WHERE scheduled_notifications.id = $1
+          AND notifications.id = scheduled_notifications.id
+          AND notifications.api_key_id = $2;
+    `
+	const cancelNotificationSQL = `
+        UPDATE notifications SET status = $2, updated_at = NOW() WHERE id = $1;
+    `
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, deleteScheduledSQL, id, apiKeyID)
+	if err != nil {
+		return fmt.Errorf("error deleting scheduled notification: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotScheduled
+	}
+	if _, err := tx.Exec(ctx, cancelNotificationSQL, id, common.CancelledStatus); err != nil {
+		return fmt.Errorf("error cancelling notification: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing cancellation: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxScheduleRepository) QueueDue(ctx context.Context, limit int) (int, error) {
+	// SKIP LOCKED lets several API replicas poll the same table without
+	// queueing a notification twice. The status only moves on from
+	// scheduled, and is written before the outbox relay can publish, so it
+	// never overwrites what the worker recorded.
+	const queueDueSQL = `
+        WITH due AS (
+            DELETE FROM scheduled_notifications
+            WHERE id IN (
+                SELECT id FROM scheduled_notifications
+                WHERE send_at <= NOW()
+                ORDER BY send_at
+                LIMIT $1
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING id, send_at, queue_name, message
+        ), queued AS (
+            UPDATE notifications SET status = $2, updated_at = NOW()
+            WHERE id IN (SELECT id FROM due) AND status = $3
+        )
+        INSERT INTO outbox (notification_id, queue_name, message)
+        SELECT id, queue_name, message FROM due
+        ORDER BY send_at;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, queueDueSQL, limit, common.QueuedStatus, common.ScheduledStatus)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing due notifications: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+)
+
+var ErrConflict = errors.New("record was modified concurrently")
+
+type Template struct {
+	Name      string                     `json:"name"`
+	Version   int                        `json:"version"`
+	Type      common.NotificationType    `json:"type"`
+	Subject   string                     `json:"subject"`
+	Body      string                     `json:"body"`
+	Localized map[string]TemplateVariant `json:"localized,omitempty"`
+	CreatedAt time.Time                  `json:"createdAt"`
+}
+
+// TemplateVariant is a template's subject and body in one locale. An empty
+// subject falls back to the template's own subject.
+type TemplateVariant struct {
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+}
+
+type TemplateRepository interface {
+	// CreateTemplateVersion stores the template as the next version of its name.
+	CreateTemplateVersion(ctx context.Context, template Template) (*Template, error)
+	// GetTemplate returns the given version, or the latest one when version is 0.
+	GetTemplate(ctx context.Context, name string, version int) (*Template, error)
+	ListTemplates(ctx context.Context) ([]Template, error)
+	// DeleteTemplate removes the given version, or every version when version is 0.
+	DeleteTemplate(ctx context.Context, name string, version int) error
+}
+
+type PgxTemplateRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewTemplateRepository(pool *pgxpool.Pool) *PgxTemplateRepository {
+	return &PgxTemplateRepository{Pool: pool}
+}
+
+func (repo *PgxTemplateRepository) CreateTemplateVersion(ctx context.Context, template Template) (*Template, error) {
+	const insertTemplateSQL = `
+        INSERT INTO templates (name, version, type, subject, body, localized)
+        SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5 FROM templates WHERE name = $1
+        RETURNING version, created_at;
+    `
+
+	localized, err := json.Marshal(template.Localized)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling localized variants: %w", err)
+	}
+	if template.Localized == nil {
+		localized = []byte("{}")
+	}
+	err = repo.Pool.QueryRow(ctx, insertTemplateSQL, template.Name, template.Type, template.Subject, template.Body, localized).
+		Scan(&template.Version, &template.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error inserting template: %w", err)
+	}
+
+	return &template, nil
+}
+
+func (repo *PgxTemplateRepository) GetTemplate(ctx context.Context, name string, version int) (*Template, error) {
+	const getTemplateSQL = `
+        SELECT name, version, type, subject, body, localized, created_at FROM templates
+        WHERE name = $1 AND ($2 = 0 OR version = $2)
+        ORDER BY version DESC
+        LIMIT 1;
+    `
+
+	var template Template
+	var localized []byte
+	err := repo.Pool.QueryRow(ctx, getTemplateSQL, name, version).Scan(
+		&template.Name,
+		&template.Version,
+		&template.Type,
+		&template.Subject,
+		&template.Body,
+		&localized,
+		&template.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying template: %w", err)
+	}
+	if err := json.Unmarshal(localized, &template.Localized); err != nil {
+		return nil, fmt.Errorf("error decoding localized variants: %w", err)
+	}
+
+	return &template, nil
+}
+
+func (repo *PgxTemplateRepository) ListTemplates(ctx context.Context) ([]Template, error) {
+	const listTemplatesSQL = `
+        SELECT DISTINCT ON (name) name, version, type, subject, body, localized, created_at FROM templates
+        ORDER BY name, version DESC;
+    `
+
+	rows, err := repo.Pool.Query(ctx, listTemplatesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []Template{}
+	for rows.Next() {
+		var template Template
+		var localized []byte
+		err := rows.Scan(
+			&template.Name,
+			&template.Version,
+			&template.Type,
+			&template.Subject,
+			&template.Body,
+			&localized,
+			&template.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning template: %w", err)
+		}
+		if err := json.Unmarshal(localized, &template.Localized); err != nil {
+			return nil, fmt.Errorf("error decoding localized variants: %w", err)
+		}
+		templates = append(templates, template)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return templates, nil
+}
+
+func (repo *PgxTemplateRepository) DeleteTemplate(ctx context.Context, name string, version int) error {
+	const deleteTemplateSQL = `
+        DELETE FROM templates WHERE name = $1 AND ($2 = 0 OR version = $2);
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteTemplateSQL, name, version)
+	if err != nil {
+		return fmt.Errorf("error deleting template: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// WebhookSubscription is where status events for an API key's notifications
+// are sent. URL may be empty when the key only uses per-notification
+// callback URLs; Secret signs the events either way.
+type WebhookSubscription struct {
+	APIKeyID  string    `json:"apiKeyId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type WebhookRepository interface {
+	// SaveWebhookSubscription creates the subscription with secret, or updates
+	// the URL of an existing one and keeps its secret. It returns the stored
+	// subscription including the secret in effect.
+	SaveWebhookSubscription(ctx context.Context, apiKeyID string, url string, secret string) (*WebhookSubscription, error)
+	// GetWebhookSubscription returns the subscription without its secret.
+	GetWebhookSubscription(ctx context.Context, apiKeyID string) (*WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, apiKeyID string) error
+}
+
+type PgxWebhookRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewWebhookRepository(pool *pgxpool.Pool) *PgxWebhookRepository {
+	return &PgxWebhookRepository{Pool: pool}
+}
+
+func (repo *PgxWebhookRepository) SaveWebhookSubscription(ctx context.Context, apiKeyID string, url string, secret string) (*WebhookSubscription, error) {
+	const upsertSubscriptionSQL = `
+        INSERT INTO webhook_subscriptions (api_key_id, url, secret)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (api_key_id) DO UPDATE
+        SET url = EXCLUDED.url, updated_at = NOW()
+        RETURNING api_key_id, url, secret, created_at, updated_at;
+    `
+
+	var subscription WebhookSubscription
+	err := repo.Pool.QueryRow(ctx, upsertSubscriptionSQL, apiKeyID, url, secret).Scan(
+		&subscription.APIKeyID,
+		&subscription.URL,
+		&subscription.Secret,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error saving webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+func (repo *PgxWebhookRepository) GetWebhookSubscription(ctx context.Context, apiKeyID string) (*WebhookSubscription, error) {
+	const getSubscriptionSQL = `
+        SELECT api_key_id, url, created_at, updated_at
+        FROM webhook_subscriptions WHERE api_key_id = $1;
+    `
+
+	var subscription WebhookSubscription
+	err := repo.Pool.QueryRow(ctx, getSubscriptionSQL, apiKeyID).Scan(
+		&subscription.APIKeyID,
+		&subscription.URL,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+func (repo *PgxWebhookRepository) DeleteWebhookSubscription(ctx context.Context, apiKeyID string) error {
+	const deleteSubscriptionSQL = `
+        DELETE FROM webhook_subscriptions WHERE api_key_id = $1;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteSubscriptionSQL, apiKeyID)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	statusOK          = "ok"
+	statusError       = "error"
+	statusDegraded    = "degraded"
+	statusUnavailable = "unavailable"
+)
+
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+	// optional checks are reported but do not fail readiness.
+	optional bool
+}
+
+// Checker runs the dependency checks behind the readiness endpoint.
+type Checker struct {
+	Timeout time.Duration
+	checks  []namedCheck
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout}
+}
+
+// Add registers a check that readiness depends on.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// AddOptional registers a check whose failure is reported in the body but
+// leaves the service ready, for dependencies it can work without.
+func (c *Checker) AddOptional(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check, optional: true})
+}
+
+// LivenessHandler only reports that the process is serving HTTP.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, report{Status: statusOK, Checks: map[string]checkResult{}})
+}
+
+// ReadinessHandler runs every check concurrently and answers 503 when any
+// required check fails, with the outcome of each check in the body. Failing
+// optional checks only mark the report as degraded. A check that
+// ignores the context and has not returned by the timeout is reported as
+// failed; the handler does not wait for it.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.Timeout)
+	defer cancel()
+
+	type namedResult struct {
+		name    string
+		outcome checkResult
+	}
+	// Buffered so checks that finish after the timeout do not block.
+	results := make(chan namedResult, len(c.checks))
+	for _, nc := range c.checks {
+		go func(nc namedCheck) {
+			outcome := checkResult{Status: statusOK}
+			if err := nc.check(ctx); err != nil {
+				outcome = checkResult{Status: statusError, Error: err.Error()}
+			}
+			results <- namedResult{name: nc.name, outcome: outcome}
+		}(nc)
+	}
+
+	result := report{Status: statusOK, Checks: make(map[string]checkResult, len(c.checks))}
+	for len(result.Checks) < len(c.checks) {
+		select {
+		case finished := <-results:
+			result.Checks[finished.name] = finished.outcome
+			continue
+		case <-ctx.Done():
+		}
+		for _, nc := range c.checks {
+			if _, ok := result.Checks[nc.name]; !ok {
+				result.Checks[nc.name] = checkResult{Status: statusError, Error: ctx.Err().Error()}
+			}
+		}
+	}
+
+	status := http.StatusOK
+	for _, nc := range c.checks {
+		switch {
+		case result.Checks[nc.name].Status == statusOK:
+		case !nc.optional:
+			result.Status = statusUnavailable
+			status = http.StatusServiceUnavailable
+		case result.Status == statusOK:
+			result.Status = statusDegraded
+		}
+	}
+	writeReport(w, status, result)
+}
+
+func writeReport(w http.ResponseWriter, status int, body report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding health report: %v", err)
+	}
+}
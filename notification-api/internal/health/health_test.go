@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		optional   map[string]Check
+		wantStatus int
+		wantReport string
+		want       map[string]string
+	}{
+		{
+			name:       "all healthy",
+			checks:     map[string]Check{"postgres": func(ctx context.Context) error { return nil }},
+			wantStatus: http.StatusOK,
+			wantReport: statusOK,
+			want:       map[string]string{"postgres": statusOK},
+		},
+		{
+			name: "one failing",
+			checks: map[string]Check{
+				"postgres": func(ctx context.Context) error { return nil },
+				"rabbitmq": func(ctx context.Context) error { return errors.New("connection closed") },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: statusUnavailable,
+			want:       map[string]string{"postgres": statusOK, "rabbitmq": statusError},
+		},
+		{
+			name:       "optional one failing",
+			checks:     map[string]Check{"postgres": func(ctx context.Context) error { return nil }},
+			optional:   map[string]Check{"rabbitmq": func(ctx context.Context) error { return errors.New("connection closed") }},
+			wantStatus: http.StatusOK,
+			wantReport: statusDegraded,
+			want:       map[string]string{"postgres": statusOK, "rabbitmq": statusError},
+		},
+		{
+			name:       "required one failing with optional ones",
+			checks:     map[string]Check{"postgres": func(ctx context.Context) error { return errors.New("connection refused") }},
+			optional:   map[string]Check{"rabbitmq": func(ctx context.Context) error { return errors.New("connection closed") }},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: statusUnavailable,
+			want:       map[string]string{"postgres": statusError, "rabbitmq": statusError},
+		},
+		{
+			name: "check ignoring the timeout",
+			checks: map[string]Check{
+				"postgres": func(ctx context.Context) error { return nil },
+				"rabbitmq": func(ctx context.Context) error { <-hang; return nil },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: statusUnavailable,
+			want:       map[string]string{"postgres": statusOK, "rabbitmq": statusError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+			for name, check := range tt.optional {
+				checker.AddOptional(name, check)
+			}
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				recorder := httptest.NewRecorder()
+				checker.ReadinessHandler(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+				done <- recorder
+			}()
+			var recorder *httptest.ResponseRecorder
+			select {
+			case recorder = <-done:
+			case <-time.After(time.Second):
+				t.Fatal("readiness handler did not return after its timeout")
+			}
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			var body report
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding report: %v", err)
+			}
+			if body.Status != tt.wantReport {
+				t.Errorf("report status = %q, want %q", body.Status, tt.wantReport)
+			}
+			for name, want := range tt.want {
+				if body.Checks[name].Status != want {
+					t.Errorf("check %s = %+v, want status %s", name, body.Checks[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+const (
+	HeaderName     = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+	maxKeyLength   = 255
+)
+
+type Middleware struct {
+	Repo db.IdempotencyRepository
+	TTL  time.Duration
+	// Lease is how long a request holds its key before a retry may take it
+	// over, in case the request died before its response was stored.
+	Lease time.Duration
+}
+
+func NewMiddleware(repo db.IdempotencyRepository, ttl time.Duration, lease time.Duration) *Middleware {
+	return &Middleware{Repo: repo, TTL: ttl, Lease: lease}
+}
+
+// Wrap replays the stored response for a repeated Idempotency-Key and rejects
+// a reused key whose request body differs from the original one.
+// Requests without the header are passed through untouched.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderName)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to the caller so two clients can never see each other's responses.
+		if identity, ok := auth.IdentityFromContext(r.Context()); ok {
+			key = identity.KeyID + ":" + key
+		}
+
+		requestHash := fingerprint(r, body)
+		record, reserved, err := m.Repo.Reserve(r.Context(), key, requestHash, m.Lease)
+		if err != nil {
+			log.Printf("Error reserving idempotency key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != requestHash:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+			case !record.Completed():
+				http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+			default:
+				replay(w, record)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// Server errors and rate limit rejections are not cached so the caller
+		// can retry with the same key.
+		ctx := context.Background()
+		if !cacheable(recorder.statusCode) {
+			if err := m.Repo.Release(ctx, record); err != nil {
+				log.Printf("Error releasing idempotency key: %v", err)
+			}
+			return
+		}
+		contentType := recorder.Header().Get("Content-Type")
+		if err := m.Repo.Complete(ctx, record, recorder.statusCode, contentType, recorder.body.Bytes(), m.TTL); err != nil {
+			log.Printf("Error storing idempotent response: %v", err)
+		}
+	})
+}
+
+// PurgeExpired periodically removes expired keys until the context is cancelled.
+func (m *Middleware) PurgeExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := m.Repo.DeleteExpired(ctx)
+			if err != nil {
+				log.Printf("Error purging idempotency keys: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Purged %d expired idempotency keys", deleted)
+			}
+		}
+	}
+}
+
+// cacheable reports whether a response is final for its key. A 429 only says
+// "not now", so replaying it would reject the retry it asked for.
+func cacheable(statusCode int) bool {
+	return statusCode != http.StatusTooManyRequests && statusCode < http.StatusInternalServerError
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method))
+	hash.Write([]byte(r.URL.Path))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record *db.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.ResponseBody)
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
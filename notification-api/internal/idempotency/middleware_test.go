@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type fakeRecord struct {
+	db.IdempotencyRecord
+	expiresAt time.Time
+}
+
+type fakeRepo struct {
+	records      map[string]*fakeRecord
+	reservations int
+}
+
+func (r *fakeRepo) Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*db.IdempotencyRecord, bool, error) {
+	if record, ok := r.records[key]; ok && record.expiresAt.After(time.Now()) {
+		return &record.IdempotencyRecord, false, nil
+	}
+	r.reservations++
+	record := &fakeRecord{
+		IdempotencyRecord: db.IdempotencyRecord{Key: key, RequestHash: requestHash, ReservationID: fmt.Sprint(r.reservations)},
+		expiresAt:         time.Now().Add(lease),
+	}
+	r.records[key] = record
+	return &record.IdempotencyRecord, true, nil
+}
+
+func (r *fakeRepo) Complete(ctx context.Context, reserved *db.IdempotencyRecord, statusCode int, contentType string, body []byte, ttl time.Duration) error {
+	record, ok := r.records[reserved.Key]
+	if !ok || record.ReservationID != reserved.ReservationID || record.Completed() {
+		return nil
+	}
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.ResponseBody = body
+	record.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (r *fakeRepo) Release(ctx context.Context, reserved *db.IdempotencyRecord) error {
+	if record, ok := r.records[reserved.Key]; ok && record.ReservationID == reserved.ReservationID && !record.Completed() {
+		delete(r.records, reserved.Key)
+	}
+	return nil
+}
+
+func (r *fakeRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    int
+		replayed bool
+	}{
+		{name: "accepted is replayed", first: http.StatusAccepted, replayed: true},
+		{name: "validation error is replayed", first: http.StatusBadRequest, replayed: true},
+		{name: "rate limited is retried", first: http.StatusTooManyRequests},
+		{name: "server error is retried", first: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := NewMiddleware(&fakeRepo{records: map[string]*fakeRecord{}}, time.Hour, time.Minute).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.WriteHeader(tt.first)
+					return
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/v1/notifications", strings.NewReader(`{}`))
+				req.Header.Set(HeaderName, "key-1")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			wantCalls := 2
+			if tt.replayed {
+				wantCalls = 1
+			}
+			if calls != wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, wantCalls)
+			}
+		})
+	}
+}
+
+func TestWrapPendingKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		lease      time.Duration
+		wantStatus int
+	}{
+		{name: "held key is rejected", lease: time.Minute, wantStatus: http.StatusConflict},
+		{name: "key past its lease is taken over", lease: -time.Second, wantStatus: http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{records: map[string]*fakeRecord{}}
+			handler := NewMiddleware(repo, time.Hour, tt.lease).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			newRequest := func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/v1/notifications", strings.NewReader(`{}`))
+				req.Header.Set(HeaderName, "key-1")
+				return req
+			}
+
+			// The first request reserved the key and died before its
+			// response was stored.
+			repo.Reserve(context.Background(), "key-1", fingerprint(newRequest(), []byte(`{}`)), tt.lease)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, newRequest())
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_api_requests_total",
+		Help: "Notification submissions by notification type and HTTP status code.",
+	}, []string{"type", "status"})
+
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notification_api_publish_duration_seconds",
+		Help:    "Time spent publishing a message to RabbitMQ.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"queue"})
+
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_api_publish_failures_total",
+		Help: "Messages that could not be published to RabbitMQ.",
+	}, []string{"queue"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObservePublish records the latency and outcome of one publish.
+func ObservePublish(queueName string, start time.Time, err error) {
+	publishDuration.WithLabelValues(queueName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		publishFailures.WithLabelValues(queueName).Inc()
+	}
+}
+
+type typeKey struct{}
+
+// SetNotificationType labels the current request with the notification type
+// once the handler has decoded it.
+func SetNotificationType(r *http.Request, notificationType string) {
+	if holder, ok := r.Context().Value(typeKey{}).(*string); ok {
+		*holder = notificationType
+	}
+}
+
+// InstrumentRequests counts every request served by next by notification
+// type and status code. Requests whose type was never set count as "unknown".
+func InstrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notificationType := "unknown"
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), typeKey{}, &notificationType)))
+		requestsTotal.WithLabelValues(notificationType, strconv.Itoa(recorder.statusCode)).Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
@@ -1,20 +1,37 @@
 package notifications
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+	"github.com/pdragnev/notification-system/notification-api/internal/outbox"
 	"github.com/pdragnev/notification-system/notification-api/internal/queue"
 )
 
+type BatchResult struct {
+	ID     string
+	Status common.NotificationStatus
+	Err    error
+}
+
 type NotificationService struct {
 	QueueClient       *queue.RabbitMQClient
 	NotificationQueue string
+	NotificationRepo  db.NotificationRepository
+	ScheduleRepo      db.ScheduleRepository
+	OutboxRepo        db.OutboxRepository
+	// Relay, when set, is woken after each enqueue so messages do not wait
+	// for its next poll.
+	Relay *outbox.Relay
 }
 
-func NewNotificationService(notificationQueueName string, config queue.RabbitMQConfig) (*NotificationService, error) {
+func NewNotificationService(notificationQueueName string, config queue.RabbitMQConfig, notificationRepo db.NotificationRepository, scheduleRepo db.ScheduleRepository, outboxRepo db.OutboxRepository) (*NotificationService, error) {
 	queueClient, err := queue.NewRabbitMQClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize RabbitMQ client: %w", err)
@@ -22,25 +39,106 @@ func NewNotificationService(notificationQueueName string, config queue.RabbitMQC
 	return &NotificationService{
 		QueueClient:       queueClient,
 		NotificationQueue: notificationQueueName,
+		NotificationRepo:  notificationRepo,
+		ScheduleRepo:      scheduleRepo,
+		OutboxRepo:        outboxRepo,
 	}, nil
 }
 
-func (s *NotificationService) SendNotification(notification common.Notification) error {
-	notificationMessage := common.NotificationMessage{
-		Notification: notification,
-		RetryCount:   0,
+func (s *NotificationService) SendNotification(notification common.Notification) (string, error) {
+	notification.ID = uuid.NewString()
+	ctx := context.Background()
+
+	if notification.IsScheduled() {
+		if err := s.scheduleNotification(ctx, notification); err != nil {
+			return "", err
+		}
+		return notification.ID, nil
+	}
+
+	notificationMessageBytes, err := json.Marshal(common.NewNotificationMessage(notification))
+	if err != nil {
+		log.Printf("Error marshaling notification message: %v", err)
+		return "", err
+	}
+
+	// The notification is accepted once it is in the outbox; the relay
+	// publishes it, so a RabbitMQ outage only delays delivery.
+	entry := db.OutboxEntry{Notification: notification, Queue: s.queueFor(notification), Message: notificationMessageBytes}
+	if err := s.OutboxRepo.Enqueue(ctx, []db.OutboxEntry{entry}); err != nil {
+		log.Printf("Error recording notification: %v", err)
+		return "", err
+	}
+	s.Relay.Wake()
+
+	log.Printf("Notification message %s added to the outbox", notification.ID)
+	return notification.ID, nil
+}
+
+// SendNotifications records a batch of notifications and adds their messages
+// to the outbox in one transaction. The results are in the same order as the
+// input.
+func (s *NotificationService) SendNotifications(batch []common.Notification) []BatchResult {
+	results := make([]BatchResult, len(batch))
+	if len(batch) == 0 {
+		return results
+	}
+	ctx := context.Background()
+
+	var entries []db.OutboxEntry
+	var entryIndexes []int
+	for i := range batch {
+		batch[i].ID = uuid.NewString()
+		results[i].ID = batch[i].ID
+		if batch[i].IsScheduled() {
+			results[i].Status = common.ScheduledStatus
+			results[i].Err = s.scheduleNotification(ctx, batch[i])
+			continue
+		}
+		results[i].Status = common.QueuedStatus
+
+		messageBytes, err := json.Marshal(common.NewNotificationMessage(batch[i]))
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		entries = append(entries, db.OutboxEntry{Notification: batch[i], Queue: s.queueFor(batch[i]), Message: messageBytes})
+		entryIndexes = append(entryIndexes, i)
+	}
+	if len(entries) == 0 {
+		return results
+	}
+
+	if err := s.OutboxRepo.Enqueue(ctx, entries); err != nil {
+		log.Printf("Error recording notification batch: %v", err)
+		for _, i := range entryIndexes {
+			results[i].Err = err
+		}
+		return results
 	}
-	notificationMessageBytes, err := json.Marshal(notificationMessage)
+	s.Relay.Wake()
+
+	log.Printf("Notification batch of %d messages added to the outbox", len(entries))
+	return results
+}
+
+func (s *NotificationService) scheduleNotification(ctx context.Context, notification common.Notification) error {
+	notificationMessageBytes, err := json.Marshal(common.NewNotificationMessage(notification))
 	if err != nil {
 		log.Printf("Error marshaling notification message: %v", err)
 		return err
 	}
 
-	if err := s.QueueClient.PublishMessage(s.NotificationQueue, notificationMessageBytes); err != nil {
-		log.Printf("Error publishing notification message: %v", err)
+	if err := s.ScheduleRepo.Schedule(ctx, notification, s.queueFor(notification), notificationMessageBytes); err != nil {
+		log.Printf("Error scheduling notification: %v", err)
 		return err
 	}
 
-	log.Printf("Notification message enqueued successfully")
+	log.Printf("Notification message %s scheduled for %s", notification.ID, notification.SendAt.Format(time.RFC3339))
 	return nil
 }
+
+// queueFor returns the priority lane the notification is published to.
+func (s *NotificationService) queueFor(notification common.Notification) string {
+	return common.QueueNameForPriority(s.NotificationQueue, notification.Priority)
+}
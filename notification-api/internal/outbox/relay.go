@@ -0,0 +1,119 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+	"github.com/pdragnev/notification-system/notification-api/internal/queue"
+)
+
+// Relay publishes pending outbox messages to RabbitMQ with publisher
+// confirms. Messages stay in the outbox until the broker confirms them, so a
+// broker outage only delays delivery.
+type Relay struct {
+	Repo        db.OutboxRepository
+	QueueClient *queue.RabbitMQClient
+	Interval    time.Duration
+	Retention   time.Duration
+	BatchSize   int
+	// MaxAttempts is how often a message is published before its
+	// notification is marked as failed.
+	MaxAttempts int
+	wake        chan struct{}
+}
+
+func NewRelay(repo db.OutboxRepository, queueClient *queue.RabbitMQClient, interval time.Duration, retention time.Duration, maxAttempts int) *Relay {
+	return &Relay{
+		Repo:        repo,
+		QueueClient: queueClient,
+		Interval:    interval,
+		Retention:   retention,
+		BatchSize:   100,
+		MaxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Wake asks the relay to publish now instead of at the next tick. It never
+// blocks.
+func (r *Relay) Wake() {
+	if r == nil {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays pending messages and purges sent ones until the context is
+// cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.publishPending(ctx)
+		case <-r.wake:
+			r.publishPending(ctx)
+		case <-cleanup.C:
+			r.deleteSent(ctx)
+		}
+	}
+}
+
+func (r *Relay) publishPending(ctx context.Context) {
+	for {
+		published, err := r.Repo.PublishPending(ctx, r.BatchSize, r.MaxAttempts, r.publish)
+		if err != nil {
+			log.Printf("Error relaying outbox messages: %v", err)
+			return
+		}
+		if published > 0 {
+			log.Printf("Relayed %d outbox messages", published)
+		}
+		if published < r.BatchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) publish(pending []db.OutboxMessage) []error {
+	messages := make([]queue.Message, len(pending))
+	for i, message := range pending {
+		messages[i] = queue.Message{Queue: message.Queue, Body: message.Message}
+	}
+
+	errs, err := r.QueueClient.PublishMessages(messages)
+	if err != nil {
+		errs = make([]error, len(pending))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Error relaying notification %s (attempt %d): %v", pending[i].NotificationID, pending[i].Attempts+1, err)
+		}
+	}
+	return errs
+}
+
+func (r *Relay) deleteSent(ctx context.Context) {
+	deleted, err := r.Repo.DeleteSent(ctx, time.Now().Add(-r.Retention))
+	if err != nil {
+		log.Printf("Error deleting sent outbox messages: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d sent outbox messages", deleted)
+	}
+}
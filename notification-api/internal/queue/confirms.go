@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var ErrNacked = errors.New("message was nacked by the broker")
+
+// ReturnedError is reported when the broker could not route a mandatory
+// message to any queue.
+type ReturnedError struct {
+	Queue     string
+	ReplyCode uint16
+	ReplyText string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("message to queue %s was returned by the broker: %d %s", e.Queue, e.ReplyCode, e.ReplyText)
+}
+
+// confirmChannel is a channel in confirm mode. It must only be used by one
+// goroutine at a time.
+type confirmChannel struct {
+	ch       *amqp091.Channel
+	returns  chan amqp091.Return
+	returned map[string]amqp091.Return
+}
+
+type pendingConfirm struct {
+	queue        string
+	messageID    string
+	confirmation *amqp091.DeferredConfirmation
+	start        time.Time
+}
+
+func newConfirmChannel(conn *amqp091.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to put channel in confirm mode: %v", err)
+	}
+	return &confirmChannel{
+		ch:       ch,
+		returns:  ch.NotifyReturn(make(chan amqp091.Return, 128)),
+		returned: make(map[string]amqp091.Return),
+	}, nil
+}
+
+func (cc *confirmChannel) Close() error {
+	return cc.ch.Close()
+}
+
+// publish sends a persistent, mandatory message without waiting for the
+// broker. Pass the result to wait to learn whether it was persisted.
+func (cc *confirmChannel) publish(ctx context.Context, queueName string, body []byte) (*pendingConfirm, error) {
+	messageID := uuid.NewString()
+	start := time.Now()
+	confirmation, err := cc.ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",        // Exchange
+		queueName, // Routing key (queue name)
+		true,      // Mandatory, unroutable messages come back as returns
+		false,     // Immediate
+		amqp091.Publishing{
+			DeliveryMode: amqp091.Persistent,
+			Timestamp:    time.Now(),
+			ContentType:  "text/plain",
+			MessageId:    messageID,
+			Body:         body,
+		},
+	)
+	cc.drainReturns()
+	if err != nil {
+		return nil, err
+	}
+	return &pendingConfirm{queue: queueName, messageID: messageID, confirmation: confirmation, start: start}, nil
+}
+
+// wait blocks until the broker acks or nacks the message, or ctx expires.
+func (cc *confirmChannel) wait(ctx context.Context, pending *pendingConfirm) error {
+	acked, err := pending.confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("timed out waiting for broker confirmation: %v", err)
+	}
+	if !acked {
+		return ErrNacked
+	}
+
+	// The broker sends basic.return before the ack of an unroutable message,
+	// so the return is already buffered once the ack arrived.
+	cc.drainReturns()
+	if ret, ok := cc.returned[pending.messageID]; ok {
+		delete(cc.returned, pending.messageID)
+		return &ReturnedError{Queue: pending.queue, ReplyCode: ret.ReplyCode, ReplyText: ret.ReplyText}
+	}
+	return nil
+}
+
+func (cc *confirmChannel) drainReturns() {
+	for {
+		select {
+		case ret := <-cc.returns:
+			cc.returned[ret.MessageId] = ret
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var ErrPoolClosed = errors.New("publisher channel pool is closed")
+
+// channelPool keeps up to size long-lived confirm channels for publishing.
+// A channel is checked out by one goroutine at a time, which is what the
+// AMQP client requires, and is replaced when the broker closes it. New
+// channels are opened on whatever connection is current, so the pool heals
+// itself after a reconnect.
+type channelPool struct {
+	conn  func() (*amqp091.Connection, error)
+	idle  chan *confirmChannel
+	slots chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newChannelPool(conn func() (*amqp091.Connection, error), size int) *channelPool {
+	return &channelPool{
+		conn:  conn,
+		idle:  make(chan *confirmChannel, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// get returns an open channel, reusing an idle one when possible and
+// opening a new one while the pool is below its size. Once every channel is
+// checked out, callers wait until one is put back or ctx is done.
+func (p *channelPool) get(ctx context.Context) (*confirmChannel, error) {
+	for {
+		if p.isClosed() {
+			return nil, ErrPoolClosed
+		}
+
+		select {
+		case cc := <-p.idle:
+			if cc.ch.IsClosed() {
+				p.discard(cc)
+				continue
+			}
+			return cc, nil
+		default:
+		}
+
+		select {
+		case cc := <-p.idle:
+			if cc.ch.IsClosed() {
+				p.discard(cc)
+				continue
+			}
+			return cc, nil
+		case p.slots <- struct{}{}:
+			conn, err := p.conn()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			cc, err := newConfirmChannel(conn)
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			return cc, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// put returns a channel to the pool. Channels that failed in a way that may
+// leave confirms outstanding (timeouts, channel errors) are closed instead so
+// the next caller never sees a stale confirmation.
+func (p *channelPool) put(cc *confirmChannel, err error) {
+	if !reusableAfter(err) || cc.ch.IsClosed() || p.isClosed() {
+		p.discard(cc)
+		return
+	}
+	select {
+	case p.idle <- cc:
+	default:
+		p.discard(cc)
+	}
+}
+
+// reusableAfter reports whether a channel is still in a known state after a
+// publish that ended with err. Nacks and returns are answers from the
+// broker; anything else may leave a confirm outstanding.
+func reusableAfter(err error) bool {
+	var returned *ReturnedError
+	return err == nil || errors.Is(err, ErrNacked) || errors.As(err, &returned)
+}
+
+func (p *channelPool) discard(cc *confirmChannel) {
+	cc.Close()
+	<-p.slots
+}
+
+func (p *channelPool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close closes the idle channels. Channels still checked out are closed when
+// they are put back.
+func (p *channelPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	for {
+		select {
+		case cc := <-p.idle:
+			p.discard(cc)
+		default:
+			return
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pdragnev/notification-system/common/rabbitmq"
+	"github.com/pdragnev/notification-system/notification-api/internal/metrics"
 	"github.com/rabbitmq/amqp091-go"
 )
 
@@ -16,6 +18,10 @@ type RabbitMQConfig struct {
 	DLXExchange       string
 	DLXQueue          string
 	NotificationQueue string
+	// ConfirmTimeout bounds the wait for the broker to confirm a publish.
+	ConfirmTimeout time.Duration
+	// ChannelPoolSize caps the number of long-lived publishing channels.
+	ChannelPoolSize int
 }
 
 func init() {
@@ -26,106 +32,153 @@ func init() {
 	}
 }
 
+type Message struct {
+	Queue string
+	Body  []byte
+}
+
 type RabbitMQClient struct {
-	Connection *amqp091.Connection
+	config         *RabbitMQConfig
+	connections    *rabbitmq.ConnectionManager
+	confirmTimeout time.Duration
+	channels       *channelPool
 }
 
 func NewRabbitMQClient(config RabbitMQConfig) (*RabbitMQClient, error) {
-	conn, err := amqp091.Dial(config.URL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	if config.NotificationQueue == "" {
+		return nil, fmt.Errorf("NotificationQueue name must not be empty")
+	}
+	confirmTimeout := config.ConfirmTimeout
+	if confirmTimeout <= 0 {
+		confirmTimeout = 5 * time.Second
+	}
+	poolSize := config.ChannelPoolSize
+	if poolSize <= 0 {
+		poolSize = 16
 	}
-	return &RabbitMQClient{Connection: conn}, nil
-}
 
-func (client *RabbitMQClient) PublishMessage(queueName string, message []byte) error {
-	ch, err := client.Connection.Channel()
+	client := &RabbitMQClient{config: &config, confirmTimeout: confirmTimeout}
+	// Queues may have been lost with the broker, so declare them again on
+	// every reconnect before publishing resumes.
+	connections, err := rabbitmq.NewConnectionManager(config.URL, client.declareTopology)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer ch.Close()
+	client.connections = connections
+	client.channels = newChannelPool(connections.Connection, poolSize)
+	return client, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	err = ch.PublishWithContext(
-		ctx,
-		"",        // Exchange
-		queueName, // Routing key (queue name)
-		false,     // Mandatory
-		false,     // Immediate
-		amqp091.Publishing{
-			DeliveryMode: amqp091.Persistent,
-			Timestamp:    time.Now(),
-			ContentType:  "text/plain",
-			Body:         message,
-		},
-	)
-	return err
+// Close closes the publishing channels and the connection.
+func (client *RabbitMQClient) Close() error {
+	client.channels.Close()
+	return client.connections.Close()
 }
 
-func (client *RabbitMQClient) SetupQueues() error {
-	ch, err := client.Connection.Channel()
+// PublishMessage publishes a message and waits until the broker confirms
+// that it persisted it. Nacks, returns and confirm timeouts are errors.
+func (client *RabbitMQClient) PublishMessage(queueName string, message []byte) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObservePublish(queueName, start, err) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.confirmTimeout)
+	defer cancel()
+
+	cc, err := client.channels.get(ctx)
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
+	defer func() { client.channels.put(cc, err) }()
 
-	dlxName := os.Getenv("DLX_EXCHANGE_NAME")
-	dlqName := os.Getenv("DLX_QUEUE_NAME")
-	primaryQueueName := os.Getenv("RABBITMQ_NOTIFICATION_QUEUE_NAME")
-
-	// Ensure DLX exists
-	err = ch.ExchangeDeclare(
-		dlxName,
-		"fanout",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	pending, err := cc.publish(ctx, queueName, message)
 	if err != nil {
-		return fmt.Errorf("failed to declare DLX: %v", err)
+		return err
 	}
+	return cc.wait(ctx, pending)
+}
+
+// PublishMessages publishes every message over a single pooled channel and
+// returns one error slot per message. All messages are sent before the
+// confirms are awaited, so the batch costs one round trip instead of one per
+// message. The returned error is set only when no channel could be obtained.
+func (client *RabbitMQClient) PublishMessages(messages []Message) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.confirmTimeout)
+	defer cancel()
 
-	_, err = ch.QueueDeclare(
-		dlqName,
-		true,
-		false,
-		false,
-		false,
-		amqp091.Table{"x-dead-letter-exchange": dlxName},
-	)
+	cc, err := client.channels.get(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to declare DLQ: %v", err)
+		return nil, err
 	}
+	var channelErr error
+	defer func() { client.channels.put(cc, channelErr) }()
 
-	// Bind DLQ to DLX
-	err = ch.QueueBind(
-		dlqName,
-		"", // routing key
-		dlxName,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to bind DLQ to DLX: %v", err)
+	errs := make([]error, len(messages))
+	pending := make([]*pendingConfirm, len(messages))
+	for i, message := range messages {
+		pending[i], errs[i] = cc.publish(ctx, message.Queue, message.Body)
+		if errs[i] != nil {
+			channelErr = errs[i]
+			metrics.ObservePublish(message.Queue, time.Now(), errs[i])
+		}
 	}
+	for i, p := range pending {
+		if p == nil {
+			continue
+		}
+		errs[i] = cc.wait(ctx, p)
+		if !reusableAfter(errs[i]) {
+			channelErr = errs[i]
+		}
+		metrics.ObservePublish(p.queue, p.start, errs[i])
+	}
+	return errs, nil
+}
 
-	// Create or ensure primary queue exists with DLX configuration
-	_, err = ch.QueueDeclare(
-		primaryQueueName,
-		true,  // Durable
-		false, // Delete when unused
-		false, // Exclusive
-		false, // No-wait
-		amqp091.Table{
-			"x-dead-letter-exchange": dlxName, // DLX
-		},
-	)
+// CheckConnection reports whether the AMQP connection is open and the broker
+// accepts publishes.
+func (client *RabbitMQClient) CheckConnection(ctx context.Context) error {
+	state, reason := client.connections.State()
+	switch state {
+	case rabbitmq.StateConnected:
+		return nil
+	case rabbitmq.StateBlocked:
+		return fmt.Errorf("RabbitMQ connection is blocked: %s", reason)
+	default:
+		return fmt.Errorf("RabbitMQ connection is %s", state)
+	}
+}
+
+// CheckQueues opens a channel and passively declares every queue, which fails
+// when a queue does not exist instead of creating it.
+func (client *RabbitMQClient) CheckQueues(ctx context.Context, queueNames []string) error {
+	conn, err := client.connections.Connection()
 	if err != nil {
-		return fmt.Errorf("failed to declare primary queue with DLX: %v", err)
+		return err
 	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer ch.Close()
 
+	for _, queueName := range queueNames {
+		if _, err := ch.QueueDeclarePassive(queueName, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("queue %s is not available: %v", queueName, err)
+		}
+	}
 	return nil
 }
+
+func (client *RabbitMQClient) SetupQueues() error {
+	conn, err := client.connections.Connection()
+	if err != nil {
+		return err
+	}
+	return client.declareTopology(conn)
+}
+
+// declareTopology declares the dead letter exchange, the DLQ and one queue
+// per priority lane on conn.
+func (client *RabbitMQClient) declareTopology(conn *amqp091.Connection) error {
+	return rabbitmq.DeclareTopology(conn, client.config.DLXExchange, client.config.DLXQueue, client.config.NotificationQueue)
+}
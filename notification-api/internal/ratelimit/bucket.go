@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens are added per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit reads a limit written as "<count>/<unit>:<burst>", for example
+// "10/s:20" or "600/m:100". The unit is one of s, m or h.
+func ParseLimit(spec string) (Limit, error) {
+	ratePart, burstPart, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 10/s:20", spec)
+	}
+	countPart, unit, ok := strings.Cut(ratePart, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 10/s:20", spec)
+	}
+
+	count, err := strconv.ParseFloat(countPart, 64)
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate in %q", spec)
+	}
+	burst, err := strconv.Atoi(burstPart)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("invalid burst in %q", spec)
+	}
+
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid unit in %q", spec)
+	}
+
+	return Limit{Rate: count / per.Seconds(), Burst: burst}, nil
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      Limit
+	Remaining  int
+	RetryAfter time.Duration
+	// ResetAfter is the time until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// Request asks for cost tokens from the bucket stored under key.
+type Request struct {
+	Key   string
+	Limit Limit
+	Cost  int
+}
+
+// takeAll refills the buckets of requests, which held tokens elapsed ago,
+// and spends every cost only when each bucket can pay its own. A cost above
+// the burst is paid from a full bucket, which then owes the rest and refills
+// from below zero, so a large batch is charged over time instead of never
+// passing. It returns the tokens left per bucket and the outcomes, and is
+// shared by every store so they all enforce the same arithmetic.
+func takeAll(tokens []float64, elapsed []time.Duration, requests []Request) ([]float64, []Result) {
+	allowed := true
+	for i, request := range requests {
+		tokens[i] = refill(tokens[i], elapsed[i], request.Limit)
+		if tokens[i] < upfront(request) {
+			allowed = false
+		}
+	}
+
+	results := make([]Result, len(requests))
+	for i, request := range requests {
+		limit := request.Limit
+		result := Result{Allowed: allowed, Limit: limit}
+		if allowed {
+			tokens[i] -= float64(request.Cost)
+		} else if missing := upfront(request) - tokens[i]; missing > 0 {
+			result.RetryAfter = time.Duration(missing / limit.Rate * float64(time.Second))
+		}
+		result.Remaining = int(math.Max(0, math.Floor(tokens[i])))
+		result.ResetAfter = time.Duration((float64(limit.Burst) - tokens[i]) / limit.Rate * float64(time.Second))
+		results[i] = result
+	}
+	return tokens, results
+}
+
+// upfront is the number of tokens a bucket must hold to take the request.
+func upfront(request Request) float64 {
+	return math.Min(float64(request.Cost), float64(request.Limit.Burst))
+}
+
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	}
+	return tokens
+}
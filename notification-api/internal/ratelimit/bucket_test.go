@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTakeAll(t *testing.T) {
+	perSecond := Limit{Rate: 1, Burst: 10}
+	tests := []struct {
+		name       string
+		tokens     []float64
+		elapsed    []time.Duration
+		requests   []Request
+		wantTokens []float64
+		want       []Result
+	}{
+		{
+			name:       "spends from a full bucket",
+			tokens:     []float64{10},
+			elapsed:    []time.Duration{0},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 4}},
+			wantTokens: []float64{6},
+			want:       []Result{{Allowed: true, Limit: perSecond, Remaining: 6, ResetAfter: 4 * time.Second}},
+		},
+		{
+			name:       "refills up to the burst",
+			tokens:     []float64{2},
+			elapsed:    []time.Duration{time.Hour},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 10}},
+			wantTokens: []float64{0},
+			want:       []Result{{Allowed: true, Limit: perSecond, Remaining: 0, ResetAfter: 10 * time.Second}},
+		},
+		{
+			name:       "short bucket waits for the missing tokens",
+			tokens:     []float64{1},
+			elapsed:    []time.Duration{time.Second},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 5}},
+			wantTokens: []float64{2},
+			want:       []Result{{Limit: perSecond, Remaining: 2, RetryAfter: 3 * time.Second, ResetAfter: 8 * time.Second}},
+		},
+		{
+			name:       "cost above the burst is owed by a full bucket",
+			tokens:     []float64{10},
+			elapsed:    []time.Duration{0},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 25}},
+			wantTokens: []float64{-15},
+			want:       []Result{{Allowed: true, Limit: perSecond, Remaining: 0, ResetAfter: 25 * time.Second}},
+		},
+		{
+			name:       "cost above the burst waits for a full bucket",
+			tokens:     []float64{4},
+			elapsed:    []time.Duration{0},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 25}},
+			wantTokens: []float64{4},
+			want:       []Result{{Limit: perSecond, Remaining: 4, RetryAfter: 6 * time.Second, ResetAfter: 6 * time.Second}},
+		},
+		{
+			name:       "a bucket in debt refills from below zero",
+			tokens:     []float64{-15},
+			elapsed:    []time.Duration{10 * time.Second},
+			requests:   []Request{{Key: "a", Limit: perSecond, Cost: 1}},
+			wantTokens: []float64{-5},
+			want:       []Result{{Limit: perSecond, RetryAfter: 6 * time.Second, ResetAfter: 15 * time.Second}},
+		},
+		{
+			name:    "one short bucket spends from none",
+			tokens:  []float64{10, 0},
+			elapsed: []time.Duration{0, 0},
+			requests: []Request{
+				{Key: "a", Limit: perSecond, Cost: 3},
+				{Key: "b", Limit: perSecond, Cost: 1},
+			},
+			wantTokens: []float64{10, 0},
+			want: []Result{
+				{Limit: perSecond, Remaining: 10},
+				{Limit: perSecond, RetryAfter: time.Second, ResetAfter: 10 * time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, results := takeAll(tt.tokens, tt.elapsed, tt.requests)
+			for i := range tt.requests {
+				if tokens[i] != tt.wantTokens[i] {
+					t.Errorf("tokens[%d] = %v, want %v", i, tokens[i], tt.wantTokens[i])
+				}
+				if results[i] != tt.want[i] {
+					t.Errorf("results[%d] = %+v, want %+v", i, results[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecisive(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		want    Result
+	}{
+		{
+			name:    "fewest remaining when allowed",
+			results: []Result{{Allowed: true, Remaining: 5}, {Allowed: true, Remaining: 2}},
+			want:    Result{Allowed: true, Remaining: 2},
+		},
+		{
+			name:    "longest wait when limited",
+			results: []Result{{RetryAfter: time.Second}, {RetryAfter: time.Minute}, {}},
+			want:    Result{RetryAfter: time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decisive(tt.results); got != tt.want {
+				t.Errorf("decisive() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreDeleteFull(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Rate: 1000, Burst: 1}
+	if _, err := store.Take(context.Background(), []Request{{Key: "a", Limit: limit, Cost: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take(context.Background(), []Request{{Key: "b", Limit: Limit{Rate: 0.001, Burst: 1}, Cost: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	deleted, err := store.DeleteFull(context.Background())
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteFull() = %d, %v, want 1", deleted, err)
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("bucket b was deleted before it refilled")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit Limit
+		err   bool
+	}{
+		{"10/s:20", Limit{Rate: 10, Burst: 20}, false},
+		{"600/m:100", Limit{Rate: 10, Burst: 100}, false},
+		{"3600/h:5", Limit{Rate: 1, Burst: 5}, false},
+		{"0.5/s:1", Limit{Rate: 0.5, Burst: 1}, false},
+		{"10/s", Limit{}, true},
+		{"10:20", Limit{}, true},
+		{"ten/s:20", Limit{}, true},
+		{"0/s:20", Limit{}, true},
+		{"-1/s:20", Limit{}, true},
+		{"10/s:0", Limit{}, true},
+		{"10/s:1.5", Limit{}, true},
+		{"10/d:20", Limit{}, true},
+		{"", Limit{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			limit, err := ParseLimit(tt.spec)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if limit != tt.limit {
+				t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.spec, limit, tt.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-api/internal/auth"
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+const overrideCacheTTL = time.Minute
+
+// Limiter resolves the limit for a caller and notification type and spends
+// tokens from the matching bucket. The most specific limit wins: a per-client
+// override for the type, a per-client override for every type, the
+// per-type default, and finally the global default.
+type Limiter struct {
+	Store     Store
+	Overrides db.RateLimitRepository
+	Default   Limit
+	PerType   map[common.NotificationType]Limit
+
+	mu    sync.Mutex
+	cache map[string]cachedOverride
+}
+
+type cachedOverride struct {
+	limit     *Limit
+	expiresAt time.Time
+}
+
+func NewLimiter(store Store, overrides db.RateLimitRepository, defaultLimit Limit, perType map[common.NotificationType]Limit) *Limiter {
+	return &Limiter{
+		Store:     store,
+		Overrides: overrides,
+		Default:   defaultLimit,
+		PerType:   perType,
+		cache:     make(map[string]cachedOverride),
+	}
+}
+
+// AllowRequest spends cost tokens for the caller behind r. Authenticated
+// callers are limited per API key, anything else per client IP.
+func (l *Limiter) AllowRequest(r *http.Request, notificationType common.NotificationType, cost int) (Result, error) {
+	return l.AllowRequests(r, map[common.NotificationType]int{notificationType: cost})
+}
+
+// AllowRequests spends tokens from the bucket of every notification type in
+// costs, or from none of them when one bucket cannot pay. The result is that
+// of the bucket that limited the request, or of the emptiest one.
+func (l *Limiter) AllowRequests(r *http.Request, costs map[common.NotificationType]int) (Result, error) {
+	ctx := r.Context()
+	identity, authenticated := auth.IdentityFromContext(ctx)
+	client := "ip:" + clientIP(r)
+	if authenticated {
+		client = "key:" + identity.KeyID
+	}
+
+	var requests []Request
+	for notificationType, cost := range costs {
+		limit := l.limitFor(notificationType)
+		if authenticated {
+			override, err := l.override(ctx, identity.KeyID, notificationType)
+			if err != nil {
+				return Result{}, err
+			}
+			if override != nil {
+				limit = *override
+			}
+		}
+		requests = append(requests, Request{Key: client + ":" + string(notificationType), Limit: limit, Cost: cost})
+	}
+
+	results, err := l.Store.Take(ctx, requests)
+	if err != nil {
+		return Result{}, err
+	}
+	return decisive(results), nil
+}
+
+// decisive picks the result to report: the one that needs the longest wait,
+// then the one with the fewest tokens left.
+func decisive(results []Result) Result {
+	var picked Result
+	for i, result := range results {
+		switch {
+		case i == 0:
+		case !result.Allowed && result.RetryAfter > picked.RetryAfter:
+		case result.Allowed && result.Remaining < picked.Remaining:
+		default:
+			continue
+		}
+		picked = result
+	}
+	return picked
+}
+
+// PurgeBuckets drops full buckets periodically until the context is
+// cancelled, so callers that went quiet do not keep theirs forever.
+func (l *Limiter) PurgeBuckets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := l.Store.DeleteFull(ctx); err != nil {
+				log.Printf("Error purging rate limit buckets: %v", err)
+			}
+			l.mu.Lock()
+			now := time.Now()
+			for key, cached := range l.cache {
+				if now.After(cached.expiresAt) {
+					delete(l.cache, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Limiter) limitFor(notificationType common.NotificationType) Limit {
+	if limit, ok := l.PerType[notificationType]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// override looks up per-client limits, caching them briefly so the table is
+// not queried on every request.
+func (l *Limiter) override(ctx context.Context, apiKeyID string, notificationType common.NotificationType) (*Limit, error) {
+	cacheKey := apiKeyID + ":" + string(notificationType)
+
+	l.mu.Lock()
+	cached, ok := l.cache[cacheKey]
+	l.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.limit, nil
+	}
+
+	var limit *Limit
+	override, err := l.Overrides.GetRateLimit(ctx, apiKeyID, notificationType)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("error resolving rate limit: %w", err)
+	default:
+		limit = &Limit{Rate: override.RatePerSecond, Burst: override.Burst}
+	}
+
+	l.mu.Lock()
+	l.cache[cacheKey] = cachedOverride{limit: limit, expiresAt: time.Now().Add(overrideCacheTTL)}
+	l.mu.Unlock()
+	return limit, nil
+}
+
+// WriteHeaders sets the X-RateLimit-* headers, and Retry-After when the
+// request was limited.
+func WriteHeaders(w http.ResponseWriter, result Result) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit.Burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Store keeps token buckets. Implementations must be safe for concurrent use.
+type Store interface {
+	// Take spends from every requested bucket, or from none of them when
+	// one cannot pay. Results are in the order of requests.
+	Take(ctx context.Context, requests []Request) ([]Result, error)
+	// DeleteFull drops buckets that refilled completely. A missing bucket
+	// starts full, so this only frees space.
+	DeleteFull(ctx context.Context) (int64, error)
+}
+
+// MemoryStore keeps buckets in process memory. It is only correct when a
+// single API replica runs.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, requests []Request) ([]Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	buckets := make([]*memoryBucket, len(requests))
+	tokens := make([]float64, len(requests))
+	elapsed := make([]time.Duration, len(requests))
+	for i, request := range requests {
+		bucket, ok := s.buckets[request.Key]
+		if !ok {
+			bucket = &memoryBucket{tokens: float64(request.Limit.Burst), updatedAt: now}
+			s.buckets[request.Key] = bucket
+		}
+		buckets[i] = bucket
+		tokens[i] = bucket.tokens
+		elapsed[i] = now.Sub(bucket.updatedAt)
+	}
+
+	tokens, results := takeAll(tokens, elapsed, requests)
+	for i, bucket := range buckets {
+		bucket.tokens = tokens[i]
+		bucket.updatedAt = now
+		bucket.fullAt = now.Add(results[i].ResetAfter)
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) DeleteFull(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// PostgresStore keeps buckets in a table so every API replica shares them.
+// The bucket rows are locked while they are updated and elapsed time is
+// measured with the database clock, so replicas with skewed clocks still agree.
+type PostgresStore struct {
+	Pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{Pool: pool}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, requests []Request) ([]Result, error) {
+	const createBucketSQL = `
+        INSERT INTO rate_limit_buckets (key, tokens) VALUES ($1, $2)
+        ON CONFLICT (key) DO NOTHING;
+    `
+	const lockBucketSQL = `
+        SELECT tokens, EXTRACT(EPOCH FROM (NOW() - updated_at))::float8 FROM rate_limit_buckets
+        WHERE key = $1
+        FOR UPDATE;
+    `
+	const updateBucketSQL = `
+        UPDATE rate_limit_buckets
+        SET tokens = $2, updated_at = NOW(), full_at = NOW() + make_interval(secs => $3)
+        WHERE key = $1;
+    `
+
+	// Rows are locked in key order so two requests for the same buckets
+	// cannot deadlock.
+	order := make([]int, len(requests))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return requests[order[a]].Key < requests[order[b]].Key })
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tokens := make([]float64, len(requests))
+	elapsed := make([]time.Duration, len(requests))
+	for _, i := range order {
+		request := requests[i]
+		if _, err := tx.Exec(ctx, createBucketSQL, request.Key, float64(request.Limit.Burst)); err != nil {
+			return nil, fmt.Errorf("error creating rate limit bucket: %w", err)
+		}
+		var elapsedSeconds float64
+		if err := tx.QueryRow(ctx, lockBucketSQL, request.Key).Scan(&tokens[i], &elapsedSeconds); err != nil {
+			return nil, fmt.Errorf("error querying rate limit bucket: %w", err)
+		}
+		elapsed[i] = time.Duration(elapsedSeconds * float64(time.Second))
+	}
+
+	tokens, results := takeAll(tokens, elapsed, requests)
+	for i, request := range requests {
+		if _, err := tx.Exec(ctx, updateBucketSQL, request.Key, tokens[i], results[i].ResetAfter.Seconds()); err != nil {
+			return nil, fmt.Errorf("error updating rate limit bucket: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing rate limit bucket: %w", err)
+	}
+	return results, nil
+}
+
+func (s *PostgresStore) DeleteFull(ctx context.Context) (int64, error) {
+	const deleteFullSQL = `
+        DELETE FROM rate_limit_buckets WHERE full_at <= NOW();
+    `
+
+	tag, err := s.Pool.Exec(ctx, deleteFullSQL)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting full rate limit buckets: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pdragnev/notification-system/notification-api/internal/db"
+)
+
+type Scheduler struct {
+	Repo      db.ScheduleRepository
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewScheduler(repo db.ScheduleRepository, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Repo:      repo,
+		Interval:  interval,
+		BatchSize: 100,
+	}
+}
+
+// Run moves due scheduled notifications to the outbox, which publishes them
+// to the notification queue, until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.queueDue(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) queueDue(ctx context.Context) {
+	for {
+		queued, err := s.Repo.QueueDue(ctx, s.BatchSize)
+		if err != nil {
+			log.Printf("Error queueing scheduled notifications: %v", err)
+			return
+		}
+		if queued > 0 {
+			log.Printf("Queued %d scheduled notifications", queued)
+		}
+		if queued < s.BatchSize {
+			return
+		}
+	}
+}
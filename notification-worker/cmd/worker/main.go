@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// The alpine images ship without zoneinfo, and quiet hours need it.
+	_ "time/tzdata"
 
+	"github.com/pdragnev/notification-system/common"
 	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/digests"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
 	"github.com/pdragnev/notification-system/notification-worker/internal/queue"
+	"github.com/pdragnev/notification-system/notification-worker/internal/webhooks"
 	"github.com/pdragnev/notification-system/notification-worker/internal/workers"
 )
 
@@ -23,27 +32,70 @@ func main() {
 	defer pool.Close()
 
 	userRepository := db.NewUserRepository(pool)
+	statusRepository := db.NewNotificationStatusRepository(pool)
+	templateRepository := db.NewTemplateRepository(pool)
+	webhookRepository := db.NewWebhookRepository(pool)
+	preferenceRepository := db.NewPreferenceRepository(pool)
+	digestRepository := db.NewDigestRepository(pool)
+	dedupeRepository := db.NewDedupeRepository(pool)
+	attachmentRepository := db.NewAttachmentRepository(pool)
 
 	//Connection to RabbitMQ
 	rabbitMQConfig := queue.RabbitMQConfig{
 		URL:               os.Getenv("RABBITMQ_URL"),
+		DLXExchange:       os.Getenv("DLX_EXCHANGE_NAME"),
+		DLXQueue:          os.Getenv("DLX_QUEUE_NAME"),
 		NotificationQueue: os.Getenv("RABBITMQ_NOTIFICATION_QUEUE_NAME"),
 	}
 	rabbitMQClient, err := queue.NewRabbitMQClient(rabbitMQConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize RabbitMQ client: %v", err)
 	}
+	if err := rabbitMQClient.SetupQueues(); err != nil {
+		log.Fatalf("Failed to setup queues: %v", err)
+	}
 	defer func() {
-		if err := rabbitMQClient.Connection.Close(); err != nil {
+		if err := rabbitMQClient.Close(); err != nil {
 			log.Printf("Failed to close RabbitMQ connection: %v", err)
 		}
 	}()
 
-	notificationWorker := workers.NewNotificationWorker(rabbitMQClient, userRepository)
+	notificationWorker := workers.NewNotificationWorker(rabbitMQClient, userRepository, statusRepository, templateRepository, webhookRepository, preferenceRepository, digestRepository, digestWindowsFromEnv(), dedupeRepository, durationFromEnv("DEDUPE_WINDOW", 10*time.Minute), attachmentRepository)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	webhookDispatcher := webhooks.NewDispatcher(webhookRepository, durationFromEnv("WEBHOOK_POLL_INTERVAL", 2*time.Second), intFromEnv("WEBHOOK_MAX_ATTEMPTS", 10))
+	go webhookDispatcher.Run(ctx)
+
+	digestFlusher := digests.NewFlusher(digestRepository, statusRepository, rabbitMQClient, durationFromEnv("DIGEST_POLL_INTERVAL", 10*time.Second))
+	go digestFlusher.Run(ctx)
+	go notificationWorker.PurgeDedupeKeys(ctx, time.Hour)
+
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := rabbitMQClient.CheckConnection(r.Context()); err != nil {
+			writeHealth(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "rabbitmq_connection": err.Error()})
+			return
+		}
+		writeHealth(w, http.StatusOK, map[string]string{"status": "ok", "rabbitmq_connection": "ok"})
+	})
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: nil,
+	}
+
+	go func() {
+		log.Println("Serving metrics and health checks on port 8080.")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe(): %v", err)
+		}
+	}()
+
 	go func() {
 		log.Println("Worker started. Press Ctrl+C to stop.")
 		notificationWorker.Start()
@@ -52,10 +104,6 @@ func main() {
 	<-ctx.Done()
 	log.Println("Shutdown signal received, initiating graceful shutdown...")
 
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: nil,
-	}
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(shutdownCtx); err != nil {
@@ -64,3 +112,47 @@ func main() {
 
 	log.Println("Worker shutdown gracefully")
 }
+
+// digestWindowsFromEnv reads DIGEST_WINDOW_<CATEGORY> for every category.
+// Marketing notifications are digested hourly by default, transactional ones
+// are never digested unless configured.
+func digestWindowsFromEnv() map[common.Category]time.Duration {
+	defaults := map[common.Category]time.Duration{common.MarketingCategory: time.Hour}
+	windows := make(map[common.Category]time.Duration, len(common.Categories))
+	for _, category := range common.Categories {
+		windows[category] = durationFromEnv("DIGEST_WINDOW_"+strings.ToUpper(string(category)), defaults[category])
+	}
+	return windows
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s value: %v", name, err)
+	}
+	return duration
+}
+
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s value: %v", name, err)
+	}
+	return parsed
+}
+
+func writeHealth(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write health response: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type AttachmentRepository interface {
+	// GetAttachments returns the stored attachments with the given IDs, keyed
+	// by lower-case ID. Unknown IDs are missing from the map.
+	GetAttachments(ctx context.Context, ids []string) (map[string]models.Attachment, error)
+}
+
+type PgxAttachmentRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewAttachmentRepository(pool *pgxpool.Pool) *PgxAttachmentRepository {
+	return &PgxAttachmentRepository{Pool: pool}
+}
+
+func (repo *PgxAttachmentRepository) GetAttachments(ctx context.Context, ids []string) (map[string]models.Attachment, error) {
+	const getAttachmentsSQL = `
+        SELECT id, filename, content_type, content FROM attachments
+        WHERE id = ANY($1);
+    `
+
+	rows, err := repo.Pool.Query(ctx, getAttachmentsSQL, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error querying attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := make(map[string]models.Attachment, len(ids))
+	for rows.Next() {
+		var attachment models.Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.Filename, &attachment.ContentType, &attachment.Content); err != nil {
+			return nil, fmt.Errorf("error scanning attachment: %w", err)
+		}
+		attachments[strings.ToLower(attachment.ID)] = attachment
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return attachments, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 )
 
 func init() {
@@ -18,9 +19,14 @@ func init() {
 	}
 }
 
+// UserRepository returns the users' addresses together with their consent;
+// it is up to the caller to skip opted-out users.
 type UserRepository interface {
-	GetUserEmailsByIds(ctx context.Context, userIds []string) ([]string, error)
-	GetUserPhonesByIds(ctx context.Context, userIds []string) ([]string, error)
+	GetUserEmailsByIds(ctx context.Context, userIds []string) ([]models.UserContact, error)
+	GetUserPhonesByIds(ctx context.Context, userIds []string) ([]models.UserContact, error)
+	// GetUserLocales returns the locale of every user that has one, keyed by
+	// lower-case user ID.
+	GetUserLocales(ctx context.Context, userIds []string) (map[string]string, error)
 }
 
 func Connect(ctx context.Context) (*pgxpool.Pool, error) {
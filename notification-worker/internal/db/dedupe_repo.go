@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type DedupeRepository interface {
+	// Claim reserves key for the notification until lease passes. When the
+	// key is claimed or was delivered within the window, it returns false
+	// and the ID of the notification holding the key.
+	Claim(ctx context.Context, key string, notificationID string, lease time.Duration) (bool, string, error)
+	// Complete keeps the key reserved for window after a delivery.
+	Complete(ctx context.Context, key string, notificationID string, window time.Duration) error
+	// Release frees a claimed key, so a retry or a later duplicate can send.
+	Release(ctx context.Context, key string, notificationID string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type PgxDedupeRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewDedupeRepository(pool *pgxpool.Pool) *PgxDedupeRepository {
+	return &PgxDedupeRepository{Pool: pool}
+}
+
+func (repo *PgxDedupeRepository) Claim(ctx context.Context, key string, notificationID string, lease time.Duration) (bool, string, error) {
+	// An expired row is taken over; that covers both old deliveries and
+	// claims of workers that died before completing or releasing them.
+	const claimSQL = `
+        INSERT INTO notification_dedupe (dedupe_key, notification_id, state, expires_at)
+        VALUES ($1, $2, 'claimed', NOW() + make_interval(secs => $3))
+        ON CONFLICT (dedupe_key) DO UPDATE
+        SET notification_id = EXCLUDED.notification_id,
+            state = EXCLUDED.state,
+            expires_at = EXCLUDED.expires_at
+        WHERE notification_dedupe.expires_at <= NOW()
+        RETURNING notification_id;
+    `
+	const holderSQL = `
+        SELECT notification_id FROM notification_dedupe WHERE dedupe_key = $1;
+    `
+
+	var holder string
+	err := repo.Pool.QueryRow(ctx, claimSQL, key, notificationID, lease.Seconds()).Scan(&holder)
+	if err == nil {
+		return true, "", nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, "", fmt.Errorf("error claiming dedupe key: %w", err)
+	}
+
+	err = repo.Pool.QueryRow(ctx, holderSQL, key).Scan(&holder)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, "", fmt.Errorf("error querying dedupe key: %w", err)
+	}
+	return false, holder, nil
+}
+
+func (repo *PgxDedupeRepository) Complete(ctx context.Context, key string, notificationID string, window time.Duration) error {
+	const completeSQL = `
+        UPDATE notification_dedupe
+        SET state = 'delivered', expires_at = NOW() + make_interval(secs => $3)
+        WHERE dedupe_key = $1 AND notification_id = $2;
+    `
+
+	if _, err := repo.Pool.Exec(ctx, completeSQL, key, notificationID, window.Seconds()); err != nil {
+		return fmt.Errorf("error completing dedupe key: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxDedupeRepository) Release(ctx context.Context, key string, notificationID string) error {
+	const releaseSQL = `
+        DELETE FROM notification_dedupe
+        WHERE dedupe_key = $1 AND notification_id = $2 AND state = 'claimed';
+    `
+
+	if _, err := repo.Pool.Exec(ctx, releaseSQL, key, notificationID); err != nil {
+		return fmt.Errorf("error releasing dedupe key: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxDedupeRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	const deleteExpiredSQL = `
+        DELETE FROM notification_dedupe WHERE expires_at <= NOW();
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired dedupe keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
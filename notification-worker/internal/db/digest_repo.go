@@ -0,0 +1,286 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+// digestClaimLease is how long a claimed digest is left to the worker that
+// claimed it before another one may flush it.
+const digestClaimLease = time.Minute
+
+type DigestRepository interface {
+	// AddToDigest buffers a rendered notification in the open digest of
+	// each recipient, opening digests that close after window, and marks it
+	// digested. Adding the same notification again, as happens on
+	// redelivery, is a no-op.
+	AddToDigest(ctx context.Context, notification common.Notification, content common.RenderedTemplate, window time.Duration) error
+	// FlushDue claims up to limit closed digests, hands them to flush outside
+	// any transaction and marks the ones it flushed without error. Several
+	// workers may flush concurrently, each digest is handed to only one of
+	// them; a claimed digest that was not flushed is handed out again once
+	// its claim ran out.
+	FlushDue(ctx context.Context, limit int, flush func(digests []models.Digest) []error) (int, error)
+	// Settle records the final status of the notification that sent a
+	// digest. The buffered notifications whose every digest now has an
+	// outcome take their status from them: failed when any digest failed,
+	// delivered when any was delivered and skipped otherwise. A skipped
+	// outcome may still be replaced, since a digest deferred or rerouted to
+	// a copy is skipped before the copy is sent. The notifications whose
+	// status changed are returned.
+	Settle(ctx context.Context, digestID string, status common.NotificationStatus, lastError string) ([]models.SettledNotification, error)
+	DeleteFlushed(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PgxDigestRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewDigestRepository(pool *pgxpool.Pool) *PgxDigestRepository {
+	return &PgxDigestRepository{Pool: pool}
+}
+
+func (repo *PgxDigestRepository) AddToDigest(ctx context.Context, notification common.Notification, content common.RenderedTemplate, window time.Duration) error {
+	const addItemSQL = `
+        WITH digest AS (
+            INSERT INTO digests (id, api_key_id, digest_key, type, category, recipient_key, recipient, from_address, closes_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() + make_interval(secs => $9))
+            ON CONFLICT (api_key_id, digest_key, type, recipient_key) WHERE flushed_at IS NULL AND claimed_at IS NULL
+            DO UPDATE SET from_address = EXCLUDED.from_address
+            RETURNING id
+        )
+        INSERT INTO digest_items (digest_id, notification_id, subject, body, callback_url)
+        SELECT id, $10, $11, $12, $13 FROM digest
+        ON CONFLICT (digest_id, notification_id) DO NOTHING;
+    `
+	// Written with the items, so a digest flushed and sent right away finds
+	// the notification digested when it settles it.
+	const markDigestedSQL = `
+        UPDATE notifications SET status = $2, updated_at = NOW()
+        WHERE id = $1 AND status <> ALL($3);
+    `
+
+	batch := &pgx.Batch{}
+	for _, recipient := range notification.To {
+		recipientJSON, err := json.Marshal(recipient)
+		if err != nil {
+			return fmt.Errorf("error marshalling recipient: %w", err)
+		}
+		batch.Queue(addItemSQL, uuid.NewString(), notification.APIKeyID, notification.DigestKey, notification.Type,
+			notification.Category.OrDefault(), recipient.Key(), recipientJSON, notification.From, window.Seconds(),
+			notification.ID, content.Subject, content.Body, notification.CallbackURL)
+	}
+	batch.Queue(markDigestedSQL, notification.ID, common.DigestedStatus, statusStrings(common.FinalStatuses))
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error adding notification to digests: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing digests: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxDigestRepository) FlushDue(ctx context.Context, limit int, flush func(digests []models.Digest) []error) (int, error) {
+	// A claimed digest no longer takes new items: a notification that
+	// arrives while it is published opens a new digest.
+	const claimDueSQL = `
+        UPDATE digests SET claimed_at = NOW()
+        WHERE id IN (
+            SELECT id FROM digests
+            WHERE flushed_at IS NULL
+              AND ((claimed_at IS NULL AND closes_at <= NOW()) OR claimed_at <= NOW() - make_interval(secs => $2))
+            ORDER BY closes_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, api_key_id, digest_key, type, category, recipient, from_address;
+    `
+	const selectItemsSQL = `
+        SELECT digest_id, notification_id, subject, body FROM digest_items
+        WHERE digest_id = ANY($1)
+        ORDER BY created_at, notification_id;
+    `
+	const markFlushedSQL = `
+        UPDATE digests SET flushed_at = NOW() WHERE id = ANY($1);
+    `
+
+	rows, err := repo.Pool.Query(ctx, claimDueSQL, limit, digestClaimLease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("error claiming digests: %w", err)
+	}
+	var due []models.Digest
+	for rows.Next() {
+		var digest models.Digest
+		var recipientJSON []byte
+		if err := rows.Scan(&digest.ID, &digest.APIKeyID, &digest.Key, &digest.Type, &digest.Category, &recipientJSON, &digest.From); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning digest: %w", err)
+		}
+		if err := json.Unmarshal(recipientJSON, &digest.Recipient); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error decoding digest recipient: %w", err)
+		}
+		due = append(due, digest)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating rows: %w", err)
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(due))
+	byID := make(map[string]*models.Digest, len(due))
+	for i := range due {
+		ids[i] = due[i].ID
+		byID[due[i].ID] = &due[i]
+	}
+	rows, err = repo.Pool.Query(ctx, selectItemsSQL, ids)
+	if err != nil {
+		return 0, fmt.Errorf("error querying digest items: %w", err)
+	}
+	for rows.Next() {
+		var digestID string
+		var item models.DigestItem
+		if err := rows.Scan(&digestID, &item.NotificationID, &item.Subject, &item.Body); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning digest item: %w", err)
+		}
+		if digest, ok := byID[digestID]; ok {
+			digest.Items = append(digest.Items, item)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	var flushedIDs []string
+	for i, err := range flush(due) {
+		if err == nil {
+			flushedIDs = append(flushedIDs, due[i].ID)
+		}
+	}
+	if len(flushedIDs) == 0 {
+		return 0, nil
+	}
+	if _, err := repo.Pool.Exec(ctx, markFlushedSQL, flushedIDs); err != nil {
+		return 0, fmt.Errorf("error marking digests flushed: %w", err)
+	}
+	return len(flushedIDs), nil
+}
+
+func (repo *PgxDigestRepository) Settle(ctx context.Context, digestID string, status common.NotificationStatus, lastError string) ([]models.SettledNotification, error) {
+	const recordOutcomeSQL = `
+        UPDATE digests SET outcome = $2, last_error = NULLIF($3, '')
+        WHERE id = $1 AND (outcome IS NULL OR (outcome = $4 AND outcome <> $2));
+    `
+	// Locking the buffered notifications makes a concurrent settle of their
+	// other digests wait, so the last one to commit sees every outcome.
+	const lockNotificationsSQL = `
+        SELECT id FROM notifications
+        WHERE id IN (SELECT notification_id FROM digest_items WHERE digest_id = $1)
+        ORDER BY id FOR UPDATE;
+    `
+	const settleNotificationsSQL = `
+        WITH settled AS (
+            SELECT digest_items.notification_id AS id,
+                   CASE
+                       WHEN bool_or(digests.outcome = ANY($2::text[])) THEN $3::text
+                       WHEN bool_or(digests.outcome = $4::text) THEN $4::text
+                       ELSE $5::text
+                   END AS status,
+                   COALESCE(max(digests.last_error) FILTER (WHERE digests.outcome = ANY($2::text[])), '') AS last_error,
+                   max(digests.api_key_id) AS api_key_id,
+                   max(digest_items.callback_url) AS callback_url
+            FROM digest_items
+            JOIN digests ON digests.id = digest_items.digest_id
+            WHERE digest_items.notification_id IN (SELECT notification_id FROM digest_items WHERE digest_id = $1)
+            GROUP BY digest_items.notification_id
+            HAVING bool_and(digests.outcome IS NOT NULL)
+        )
+        UPDATE notifications SET status = settled.status, last_error = NULLIF(settled.last_error, ''), updated_at = NOW()
+        FROM settled
+        WHERE notifications.id = settled.id
+          AND notifications.status IN ($6::text, $5::text)
+          AND notifications.status <> settled.status
+        RETURNING notifications.id, settled.status, settled.last_error, settled.api_key_id, settled.callback_url;
+    `
+
+	tx, err := repo.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, recordOutcomeSQL, digestID, string(status), lastError, string(common.SkippedStatus))
+	if err != nil {
+		return nil, fmt.Errorf("error recording digest outcome: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+	if _, err := tx.Exec(ctx, lockNotificationsSQL, digestID); err != nil {
+		return nil, fmt.Errorf("error locking digested notifications: %w", err)
+	}
+	failed := statusStrings([]common.NotificationStatus{common.FailedStatus, common.DeadLetteredStatus})
+	rows, err := tx.Query(ctx, settleNotificationsSQL, digestID, failed, string(common.FailedStatus),
+		string(common.DeliveredStatus), string(common.SkippedStatus), string(common.DigestedStatus))
+	if err != nil {
+		return nil, fmt.Errorf("error settling digested notifications: %w", err)
+	}
+	var settled []models.SettledNotification
+	for rows.Next() {
+		var notification models.SettledNotification
+		if err := rows.Scan(&notification.Notification.ID, &notification.Status, &notification.LastError,
+			&notification.Notification.APIKeyID, &notification.Notification.CallbackURL); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning settled notification: %w", err)
+		}
+		settled = append(settled, notification)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing digest outcome: %w", err)
+	}
+	return settled, nil
+}
+
+func (repo *PgxDigestRepository) DeleteFlushed(ctx context.Context, before time.Time) (int64, error) {
+	const deleteFlushedSQL = `
+        DELETE FROM digests WHERE flushed_at < $1;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteFlushedSQL, before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting flushed digests: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func statusStrings(statuses []common.NotificationStatus) []string {
+	strs := make([]string, len(statuses))
+	for i, status := range statuses {
+		strs[i] = string(status)
+	}
+	return strs
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type NotificationStatusRepository interface {
+	UpdateStatus(ctx context.Context, notification common.Notification, status common.NotificationStatus, retryCount int, lastError string) error
+	// CreateNotification records a notification the worker itself queues. It
+	// reports false when the notification exists and has left the given
+	// status, so a retried caller does not publish it twice.
+	CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) (bool, error)
+	// RecordSkippedRecipients stores why users were not sent to. Recording the
+	// same user again, as happens on retries, is a no-op.
+	RecordSkippedRecipients(ctx context.Context, notification common.Notification, skipped []models.SkippedRecipient) error
+}
+
+type PgxNotificationStatusRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewNotificationStatusRepository(pool *pgxpool.Pool) *PgxNotificationStatusRepository {
+	return &PgxNotificationStatusRepository{Pool: pool}
+}
+
+func (repo *PgxNotificationStatusRepository) UpdateStatus(ctx context.Context, notification common.Notification, status common.NotificationStatus, retryCount int, lastError string) error {
+	const upsertStatusSQL = `
+        INSERT INTO notifications (id, type, status, retry_count, last_error, api_key_id)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+        ON CONFLICT (id) DO UPDATE
+        SET status = EXCLUDED.status,
+            retry_count = EXCLUDED.retry_count,
+            last_error = EXCLUDED.last_error,
+            updated_at = NOW();
+    `
+
+	_, err := repo.Pool.Exec(ctx, upsertStatusSQL, notification.ID, notification.Type, status, retryCount, lastError, notification.APIKeyID)
+	if err != nil {
+		return fmt.Errorf("error updating notification status: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxNotificationStatusRepository) CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) (bool, error) {
+	const createNotificationSQL = `
+        INSERT INTO notifications (id, type, status, retry_count, api_key_id)
+        VALUES ($1, $2, $3, 0, $4)
+        ON CONFLICT (id) DO UPDATE
+        SET updated_at = NOW()
+        WHERE notifications.status = EXCLUDED.status
+        RETURNING id;
+    `
+
+	var id string
+	err := repo.Pool.QueryRow(ctx, createNotificationSQL, notification.ID, notification.Type, status, notification.APIKeyID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error creating notification: %w", err)
+	}
+	return true, nil
+}
+
+func (repo *PgxNotificationStatusRepository) RecordSkippedRecipients(ctx context.Context, notification common.Notification, skipped []models.SkippedRecipient) error {
+	const insertSkippedSQL = `
+        INSERT INTO notification_skipped_recipients (notification_id, user_id, channel, reason, rerouted_to)
+        VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+        ON CONFLICT (notification_id, user_id) DO NOTHING;
+    `
+
+	batch := &pgx.Batch{}
+	for _, recipient := range skipped {
+		batch.Queue(insertSkippedSQL, notification.ID, recipient.UserID, notification.Type, recipient.Reason, recipient.ReroutedTo)
+	}
+	if err := repo.Pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error recording skipped recipients: %w", err)
+	}
+	return nil
+}
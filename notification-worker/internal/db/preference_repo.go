@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type PreferenceRepository interface {
+	// GetPreferences returns the preferences of the given users for one
+	// category, keyed by lower-case user ID. Users without stored
+	// preferences are missing from the map.
+	GetPreferences(ctx context.Context, userIds []string, category common.Category) (map[string]models.UserPreference, error)
+}
+
+type PgxPreferenceRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewPreferenceRepository(pool *pgxpool.Pool) *PgxPreferenceRepository {
+	return &PgxPreferenceRepository{Pool: pool}
+}
+
+func (repo *PgxPreferenceRepository) GetPreferences(ctx context.Context, userIds []string, category common.Category) (map[string]models.UserPreference, error) {
+	const getPreferredChannelsSQL = `
+        SELECT user_id, preferred_channel FROM user_preferences
+        WHERE user_id = ANY($1) AND preferred_channel IS NOT NULL;
+    `
+	const getDisabledChannelsSQL = `
+        SELECT user_id, channel FROM user_category_preferences
+        WHERE user_id = ANY($1) AND category = $2 AND NOT enabled;
+    `
+
+	ids := make([]interface{}, len(userIds))
+	for i, id := range userIds {
+		ids[i] = id
+	}
+
+	preferences := make(map[string]models.UserPreference)
+	rows, err := repo.Pool.Query(ctx, getPreferredChannelsSQL, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error querying preferred channels: %w", err)
+	}
+	for rows.Next() {
+		var userID string
+		var channel common.NotificationType
+		if err := rows.Scan(&userID, &channel); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning preferred channel: %w", err)
+		}
+		preference := preferences[strings.ToLower(userID)]
+		preference.PreferredChannel = channel
+		preferences[strings.ToLower(userID)] = preference
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	rows, err = repo.Pool.Query(ctx, getDisabledChannelsSQL, ids, category)
+	if err != nil {
+		return nil, fmt.Errorf("error querying category preferences: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		var channel common.NotificationType
+		if err := rows.Scan(&userID, &channel); err != nil {
+			return nil, fmt.Errorf("error scanning category preference: %w", err)
+		}
+		preference := preferences[strings.ToLower(userID)]
+		if preference.Disabled == nil {
+			preference.Disabled = make(map[common.NotificationType]bool)
+		}
+		preference.Disabled[channel] = true
+		preferences[strings.ToLower(userID)] = preference
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return preferences, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+type TemplateRepository interface {
+	GetTemplate(ctx context.Context, name string, version int) (*models.Template, error)
+}
+
+type PgxTemplateRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewTemplateRepository(pool *pgxpool.Pool) *PgxTemplateRepository {
+	return &PgxTemplateRepository{Pool: pool}
+}
+
+// GetTemplate returns the given version, or the latest one when version is 0.
+func (repo *PgxTemplateRepository) GetTemplate(ctx context.Context, name string, version int) (*models.Template, error) {
+	const getTemplateSQL = `
+        SELECT name, version, type, subject, body, localized FROM templates
+        WHERE name = $1 AND ($2 = 0 OR version = $2)
+        ORDER BY version DESC
+        LIMIT 1;
+    `
+
+	var template models.Template
+	var localized []byte
+	err := repo.Pool.QueryRow(ctx, getTemplateSQL, name, version).Scan(
+		&template.Name,
+		&template.Version,
+		&template.Type,
+		&template.Subject,
+		&template.Body,
+		&localized,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying template: %w", err)
+	}
+	if err := json.Unmarshal(localized, &template.Localized); err != nil {
+		return nil, fmt.Errorf("error decoding localized variants: %w", err)
+	}
+
+	return &template, nil
+}
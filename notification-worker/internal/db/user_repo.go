@@ -3,8 +3,10 @@ package db
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 )
 
 type PgxUserRepository struct {
@@ -15,66 +17,94 @@ func NewUserRepository(pool *pgxpool.Pool) *PgxUserRepository {
 	return &PgxUserRepository{Pool: pool}
 }
 
-func (repo *PgxUserRepository) GetUserEmailsByIds(ctx context.Context, userIds []string) ([]string, error) {
+func (repo *PgxUserRepository) GetUserEmailsByIds(ctx context.Context, userIds []string) ([]models.UserContact, error) {
+	const getEmailsSQL = `
+        SELECT u.id, u.email, COALESCE(u.opted_in, TRUE), COALESCE(c.opted_in, TRUE),
+            u.time_zone, COALESCE(to_char(u.quiet_hours_start, 'HH24:MI'), ''), COALESCE(to_char(u.quiet_hours_end, 'HH24:MI'), '')
+        FROM users u
+        LEFT JOIN user_channel_consent c ON c.user_id = u.id AND c.channel = 'email'
+        WHERE u.id = ANY($1);
+    `
+
+	contacts, err := repo.getContacts(ctx, getEmailsSQL, userIds)
+	if err != nil {
+		return nil, fmt.Errorf("error querying user emails: %w", err)
+	}
+	return contacts, nil
+}
+
+func (repo *PgxUserRepository) GetUserPhonesByIds(ctx context.Context, userIds []string) ([]models.UserContact, error) {
+	const getPhoneNumberSQL = `
+        SELECT u.id, COALESCE(u.phone_number, ''), COALESCE(u.opted_in, TRUE), COALESCE(c.opted_in, TRUE),
+            u.time_zone, COALESCE(to_char(u.quiet_hours_start, 'HH24:MI'), ''), COALESCE(to_char(u.quiet_hours_end, 'HH24:MI'), '')
+        FROM users u
+        LEFT JOIN user_channel_consent c ON c.user_id = u.id AND c.channel = 'sms'
+        WHERE u.id = ANY($1);
+    `
+
+	contacts, err := repo.getContacts(ctx, getPhoneNumberSQL, userIds)
+	if err != nil {
+		return nil, fmt.Errorf("error querying user phoneNumber: %w", err)
+	}
+	return contacts, nil
+}
+
+func (repo *PgxUserRepository) GetUserLocales(ctx context.Context, userIds []string) (map[string]string, error) {
+	const getLocalesSQL = `
+        SELECT id, locale FROM users WHERE id = ANY($1) AND locale IS NOT NULL;
+    `
+
 	ids := make([]interface{}, len(userIds))
 	for i, id := range userIds {
 		ids[i] = id
 	}
 
-	const getEmailsSQL = `
-        SELECT email FROM users WHERE id = ANY($1);
-    `
-
-	rows, err := repo.Pool.Query(ctx, getEmailsSQL, ids)
+	rows, err := repo.Pool.Query(ctx, getLocalesSQL, ids)
 	if err != nil {
-		return nil, fmt.Errorf("error querying user emails: %w", err)
+		return nil, fmt.Errorf("error querying user locales: %w", err)
 	}
 	defer rows.Close()
 
-	var emails []string
+	locales := make(map[string]string)
 	for rows.Next() {
-		var email string
-		if err := rows.Scan(&email); err != nil {
-			return nil, fmt.Errorf("error scanning email: %w", err)
+		var userID, locale string
+		if err := rows.Scan(&userID, &locale); err != nil {
+			return nil, fmt.Errorf("error scanning locale: %w", err)
 		}
-		emails = append(emails, email)
+		locales[strings.ToLower(userID)] = locale
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
 
-	return emails, nil
+	return locales, nil
 }
 
-func (repo *PgxUserRepository) GetUserPhonesByIds(ctx context.Context, userIds []string) ([]string, error) {
+func (repo *PgxUserRepository) getContacts(ctx context.Context, query string, userIds []string) ([]models.UserContact, error) {
 	ids := make([]interface{}, len(userIds))
 	for i, id := range userIds {
 		ids[i] = id
 	}
 
-	const getPhoneNumberSQL = `
-        SELECT phone_number FROM users WHERE id = ANY($1);
-    `
-
-	rows, err := repo.Pool.Query(ctx, getPhoneNumberSQL, ids)
+	rows, err := repo.Pool.Query(ctx, query, ids)
 	if err != nil {
-		return nil, fmt.Errorf("error querying user phoneNumber: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	var phone_numbers []string
+	var contacts []models.UserContact
 	for rows.Next() {
-		var phone_number string
-		if err := rows.Scan(&phone_number); err != nil {
-			return nil, fmt.Errorf("error scanning phone numbers: %w", err)
+		var contact models.UserContact
+		if err := rows.Scan(&contact.UserID, &contact.Address, &contact.OptedIn, &contact.ChannelOptedIn, &contact.TimeZone, &contact.QuietHours.Start, &contact.QuietHours.End); err != nil {
+			return nil, fmt.Errorf("error scanning contact: %w", err)
 		}
-		phone_numbers = append(phone_numbers, phone_number)
+		contacts = append(contacts, contact)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
 
-	return phone_numbers, nil
+	return contacts, nil
 }
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type WebhookDelivery struct {
+	ID             string
+	NotificationID string
+	URL            string
+	Secret         string
+	Payload        []byte
+	Attempts       int
+}
+
+type WebhookRepository interface {
+	// EnqueueWebhook stores an event for the API key's webhook subscription.
+	// callbackURL overrides the subscription URL. Nothing is stored when the
+	// key has no subscription or there is no URL to send to.
+	EnqueueWebhook(ctx context.Context, id string, apiKeyID string, notificationID string, callbackURL string, payload []byte) error
+	// ClaimWebhooks leases up to limit due deliveries so other workers skip
+	// them until the lease runs out.
+	ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id string) error
+	// MarkWebhookFailed schedules another attempt with exponential backoff, or
+	// gives up once maxAttempts is reached and reports that it did.
+	MarkWebhookFailed(ctx context.Context, id string, lastError string, maxAttempts int) (bool, error)
+	// DeleteFinishedWebhooks removes deliveries that succeeded or were given
+	// up on before the given time.
+	DeleteFinishedWebhooks(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PgxWebhookRepository struct {
+	Pool *pgxpool.Pool
+}
+
+func NewWebhookRepository(pool *pgxpool.Pool) *PgxWebhookRepository {
+	return &PgxWebhookRepository{Pool: pool}
+}
+
+func (repo *PgxWebhookRepository) EnqueueWebhook(ctx context.Context, id string, apiKeyID string, notificationID string, callbackURL string, payload []byte) error {
+	const insertDeliverySQL = `
+        INSERT INTO webhook_deliveries (id, api_key_id, notification_id, url, payload)
+        SELECT $1, api_key_id, $3, COALESCE(NULLIF($4, ''), url), $5
+        FROM webhook_subscriptions
+        WHERE api_key_id = $2 AND COALESCE(NULLIF($4, ''), url) <> '';
+    `
+
+	if _, err := repo.Pool.Exec(ctx, insertDeliverySQL, id, apiKeyID, notificationID, callbackURL, payload); err != nil {
+		return fmt.Errorf("error inserting webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxWebhookRepository) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	const claimDeliveriesSQL = `
+        WITH claimed AS (
+            UPDATE webhook_deliveries
+            SET next_attempt_at = NOW() + make_interval(secs => $2)
+            WHERE id IN (
+                SELECT id FROM webhook_deliveries
+                WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+                ORDER BY next_attempt_at
+                LIMIT $1
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING id, api_key_id, notification_id, url, payload, attempts
+        )
+        SELECT claimed.id, claimed.notification_id, claimed.url, s.secret, claimed.payload, claimed.attempts
+        FROM claimed JOIN webhook_subscriptions s ON s.api_key_id = claimed.api_key_id;
+    `
+
+	rows, err := repo.Pool.Query(ctx, claimDeliveriesSQL, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.NotificationID, &delivery.URL, &delivery.Secret, &delivery.Payload, &delivery.Attempts); err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (repo *PgxWebhookRepository) MarkWebhookDelivered(ctx context.Context, id string) error {
+	const markDeliveredSQL = `
+        UPDATE webhook_deliveries
+        SET delivered_at = NOW(), attempts = attempts + 1, last_error = NULL
+        WHERE id = $1;
+    `
+
+	if _, err := repo.Pool.Exec(ctx, markDeliveredSQL, id); err != nil {
+		return fmt.Errorf("error marking webhook delivered: %w", err)
+	}
+	return nil
+}
+
+func (repo *PgxWebhookRepository) MarkWebhookFailed(ctx context.Context, id string, lastError string, maxAttempts int) (bool, error) {
+	// Back off 10s, 20s, 40s, ... up to an hour between attempts.
+	const markFailedSQL = `
+        UPDATE webhook_deliveries
+        SET attempts = attempts + 1,
+            last_error = $2,
+            failed_at = CASE WHEN attempts + 1 >= $3 THEN NOW() END,
+            next_attempt_at = NOW() + make_interval(secs => LEAST(10 * POWER(2, attempts), 3600))
+        WHERE id = $1
+        RETURNING failed_at IS NOT NULL;
+    `
+
+	var gaveUp bool
+	if err := repo.Pool.QueryRow(ctx, markFailedSQL, id, lastError, maxAttempts).Scan(&gaveUp); err != nil {
+		return false, fmt.Errorf("error marking webhook failed: %w", err)
+	}
+	return gaveUp, nil
+}
+
+func (repo *PgxWebhookRepository) DeleteFinishedWebhooks(ctx context.Context, before time.Time) (int64, error) {
+	const deleteFinishedSQL = `
+        DELETE FROM webhook_deliveries WHERE delivered_at < $1 OR failed_at < $1;
+    `
+
+	tag, err := repo.Pool.Exec(ctx, deleteFinishedSQL, before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting finished webhook deliveries: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,170 @@
+package digests
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+	"github.com/pdragnev/notification-system/notification-worker/internal/notifications"
+)
+
+// flushConcurrency bounds how many digests of a batch are published at once.
+const flushConcurrency = 8
+
+// Flusher sends digests whose window has closed. Each digest is published as
+// an ordinary notification, so consent, preferences and quiet hours apply to
+// it like to any other. The worker settles the buffered notifications once
+// it is done with the digest notification.
+type Flusher struct {
+	Repo       db.DigestRepository
+	StatusRepo db.NotificationStatusRepository
+	Publisher  notifications.Publisher
+	Interval   time.Duration
+	BatchSize  int
+	Retention  time.Duration
+}
+
+func NewFlusher(repo db.DigestRepository, statusRepo db.NotificationStatusRepository, publisher notifications.Publisher, interval time.Duration) *Flusher {
+	return &Flusher{
+		Repo:       repo,
+		StatusRepo: statusRepo,
+		Publisher:  publisher,
+		Interval:   interval,
+		BatchSize:  100,
+		Retention:  7 * 24 * time.Hour,
+	}
+}
+
+// Run flushes closed digests and purges old ones until the context is
+// cancelled.
+func (f *Flusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.flushDue(ctx)
+		case <-cleanup.C:
+			deleted, err := f.Repo.DeleteFlushed(ctx, time.Now().Add(-f.Retention))
+			if err != nil {
+				log.Printf("Error deleting flushed digests: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d flushed digests", deleted)
+			}
+		}
+	}
+}
+
+func (f *Flusher) flushDue(ctx context.Context) {
+	for {
+		flushed, err := f.Repo.FlushDue(ctx, f.BatchSize, func(digests []models.Digest) []error {
+			return f.flushAll(ctx, digests)
+		})
+		if err != nil {
+			log.Printf("Error flushing digests: %v", err)
+			return
+		}
+		if flushed < f.BatchSize {
+			return
+		}
+	}
+}
+
+// flushAll publishes a batch of digests, a few at a time.
+func (f *Flusher) flushAll(ctx context.Context, digests []models.Digest) []error {
+	errs := make([]error, len(digests))
+	sem := make(chan struct{}, flushConcurrency)
+	var wg sync.WaitGroup
+	for i, digest := range digests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, digest models.Digest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = f.flush(ctx, digest)
+			if errs[i] != nil {
+				log.Printf("Failed to flush digest %s: %v", digest.ID, errs[i])
+			}
+		}(i, digest)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (f *Flusher) flush(ctx context.Context, digest models.Digest) error {
+	notification := Compose(digest)
+	created, err := f.StatusRepo.CreateNotification(ctx, notification, common.QueuedStatus)
+	if err != nil {
+		return fmt.Errorf("failed to record digest notification: %v", err)
+	}
+	// A digest the worker already picked up was published by an earlier
+	// flush whose commit failed.
+	if !created {
+		return nil
+	}
+	if err := f.Publisher.PublishNotification(common.NewCopyMessage(notification)); err != nil {
+		return fmt.Errorf("failed to publish digest notification: %v", err)
+	}
+	log.Printf("Flushed digest %s with %d notifications", digest.ID, len(digest.Items))
+	return nil
+}
+
+// Compose builds the notification that delivers a digest. A digest of one
+// item is sent as that item; longer ones list every item, and the items that
+// do not fit in the channel's content limit are summed up at the end.
+func Compose(digest models.Digest) common.Notification {
+	notification := common.Notification{
+		ID:       digest.ID,
+		Type:     digest.Type,
+		To:       []common.Recipient{digest.Recipient},
+		From:     digest.From,
+		Category: digest.Category,
+		APIKeyID: digest.APIKeyID,
+		DigestID: digest.ID,
+	}
+	if len(digest.Items) == 1 {
+		notification.Subject = digest.Items[0].Subject
+		notification.Content = digest.Items[0].Body
+		return notification
+	}
+
+	limit := common.MaxEmailContentLength
+	if digest.Type == common.SmsNotificationType {
+		limit = common.MaxSmsContentLength
+	} else {
+		notification.Subject = fmt.Sprintf("You have %d new notifications", len(digest.Items))
+	}
+
+	var sections []string
+	length := 0
+	for i, item := range digest.Items {
+		section := item.Body
+		if digest.Type == common.EmailNotificationType && item.Subject != "" {
+			section = item.Subject + "\n" + item.Body
+		}
+		// Keep room for the summary of the items left out.
+		more := fmt.Sprintf("...and %d more", len(digest.Items)-i)
+		if length+utf8.RuneCountInString(section)+len(more)+4 > limit {
+			sections = append(sections, more)
+			break
+		}
+		sections = append(sections, section)
+		length += utf8.RuneCountInString(section) + 2
+	}
+	notification.Content = strings.Join(sections, "\n\n")
+	return notification
+}
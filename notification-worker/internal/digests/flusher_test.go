@@ -0,0 +1,85 @@
+package digests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+func TestCompose(t *testing.T) {
+	digest := func(notificationType common.NotificationType, items ...models.DigestItem) models.Digest {
+		return models.Digest{
+			ID:        "7d1c1f0e-3b7a-4c55-9d0e-2a6b1c2b7e11",
+			APIKeyID:  "key-a",
+			Type:      notificationType,
+			Category:  common.Category("marketing"),
+			Recipient: common.Recipient{Email: "user@example.com"},
+			From:      "noreply@example.com",
+			Items:     items,
+		}
+	}
+	sms := func(body string) models.DigestItem { return models.DigestItem{Body: body} }
+
+	var many []models.DigestItem
+	for i := 0; i < 100; i++ {
+		many = append(many, sms(strings.Repeat("x", 30)))
+	}
+
+	tests := []struct {
+		name    string
+		digest  models.Digest
+		subject string
+		content string
+	}{
+		{
+			"one item is sent as is",
+			digest(common.EmailNotificationType, models.DigestItem{Subject: "Order shipped", Body: "It is on its way."}),
+			"Order shipped",
+			"It is on its way.",
+		},
+		{
+			"email lists subjects and bodies",
+			digest(common.EmailNotificationType,
+				models.DigestItem{Subject: "Order shipped", Body: "It is on its way."},
+				models.DigestItem{Body: "New comment."}),
+			"You have 2 new notifications",
+			"Order shipped\nIt is on its way.\n\nNew comment.",
+		},
+		{
+			"sms lists bodies without a subject",
+			digest(common.SmsNotificationType, sms("First"), sms("Second")),
+			"",
+			"First\n\nSecond",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := Compose(tt.digest)
+			if notification.Subject != tt.subject {
+				t.Errorf("subject = %q, want %q", notification.Subject, tt.subject)
+			}
+			if notification.Content != tt.content {
+				t.Errorf("content = %q, want %q", notification.Content, tt.content)
+			}
+			if notification.ID != tt.digest.ID || notification.DigestID != tt.digest.ID || notification.APIKeyID != "key-a" || len(notification.To) != 1 {
+				t.Errorf("notification = %+v, want the digest's ID, API key and recipient", notification)
+			}
+		})
+	}
+
+	t.Run("sms over the limit sums up the rest", func(t *testing.T) {
+		notification := Compose(digest(common.SmsNotificationType, many...))
+		if length := utf8.RuneCountInString(notification.Content); length > common.MaxSmsContentLength {
+			t.Fatalf("content is %d characters, over the limit of %d", length, common.MaxSmsContentLength)
+		}
+		sections := strings.Split(notification.Content, "\n\n")
+		want := fmt.Sprintf("...and %d more", len(many)-(len(sections)-1))
+		if last := sections[len(sections)-1]; last != want {
+			t.Errorf("last section = %q, want %q", last, want)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Message outcomes, matching how a delivery leaves the worker.
+const (
+	OutcomeAck     = "ack"
+	OutcomeRetry   = "retry"
+	OutcomeDLQ     = "dlq"
+	OutcomeRequeue = "requeue"
+)
+
+// Webhook delivery outcomes.
+const (
+	WebhookDelivered = "delivered"
+	WebhookRetry     = "retry"
+	WebhookFailed    = "failed"
+)
+
+const (
+	ProviderMandrill = "mandrill"
+	ProviderTwilio   = "twilio"
+)
+
+var (
+	messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_worker_messages_total",
+		Help: "Consumed messages by outcome: ack, retry, dlq or requeue.",
+	}, []string{"outcome"})
+
+	processDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notification_worker_process_duration_seconds",
+		Help:    "Time spent in a processor's Process call by notification type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type"})
+
+	inFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notification_worker_in_flight",
+		Help: "Messages being processed right now.",
+	})
+
+	maxWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "notification_worker_max_workers",
+		Help: "Size of the MAX_WORKERS semaphore.",
+	})
+
+	providerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_worker_provider_errors_total",
+		Help: "Failed calls to a delivery provider.",
+	}, []string{"provider"})
+
+	duplicates = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "notification_worker_duplicates_total",
+		Help: "Messages dropped because their dedupe key was claimed or delivered recently.",
+	})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_worker_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by outcome: delivered, retry or failed.",
+	}, []string{"outcome"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func CountMessage(outcome string) {
+	messagesTotal.WithLabelValues(outcome).Inc()
+}
+
+func ObserveProcess(notificationType string, start time.Time) {
+	processDuration.WithLabelValues(notificationType).Observe(time.Since(start).Seconds())
+}
+
+func SetMaxWorkers(n int) {
+	maxWorkers.Set(float64(n))
+}
+
+func IncInFlight() {
+	inFlight.Inc()
+}
+
+func DecInFlight() {
+	inFlight.Dec()
+}
+
+func CountProviderError(provider string) {
+	providerErrors.WithLabelValues(provider).Inc()
+}
+
+func CountWebhook(outcome string) {
+	webhookDeliveries.WithLabelValues(outcome).Inc()
+}
+
+func CountDuplicate() {
+	duplicates.Inc()
+}
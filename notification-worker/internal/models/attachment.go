@@ -0,0 +1,9 @@
+package models
+
+// Attachment is a stored email attachment.
+type Attachment struct {
+	ID          string
+	Filename    string
+	ContentType string
+	Content     []byte
+}
@@ -0,0 +1,31 @@
+package models
+
+import "github.com/pdragnev/notification-system/common"
+
+// Digest is the set of notifications buffered for one recipient under one
+// digest key, ready to be sent as a single notification.
+type Digest struct {
+	ID        string
+	APIKeyID  string
+	Key       string
+	Type      common.NotificationType
+	Category  common.Category
+	Recipient common.Recipient
+	From      string
+	Items     []DigestItem
+}
+
+// DigestItem is one buffered notification, already rendered to plain text.
+type DigestItem struct {
+	NotificationID string
+	Subject        string
+	Body           string
+}
+
+// SettledNotification is a buffered notification whose digests have all been
+// sent or given up on, with the status it ended in.
+type SettledNotification struct {
+	Notification common.Notification
+	Status       common.NotificationStatus
+	LastError    string
+}
@@ -59,3 +59,49 @@ func NewMaxRetryError(msg string) error {
 		Msg: msg,
 	}
 }
+
+type TemplateError struct {
+	Msg string
+}
+
+func (e *TemplateError) Error() string {
+	return e.Msg
+}
+
+func NewTemplateError(msg string) error {
+	return &TemplateError{
+		Msg: msg,
+	}
+}
+
+// AttachmentError means an attachment of the notification is gone, which
+// fails the same way on every attempt.
+type AttachmentError struct {
+	Msg string
+}
+
+func (e *AttachmentError) Error() string {
+	return e.Msg
+}
+
+func NewAttachmentError(msg string) error {
+	return &AttachmentError{
+		Msg: msg,
+	}
+}
+
+// NoRecipientsError means every recipient was skipped, so there is nothing
+// to send and nothing to retry.
+type NoRecipientsError struct {
+	Msg string
+}
+
+func (e *NoRecipientsError) Error() string {
+	return e.Msg
+}
+
+func NewNoRecipientsError(msg string) error {
+	return &NoRecipientsError{
+		Msg: msg,
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import "github.com/pdragnev/notification-system/common"
+
+// UserContact is a user's address on one channel with their consent.
+// ChannelOptedIn is true unless the user opted out of that channel.
+type UserContact struct {
+	UserID         string
+	Address        string
+	OptedIn        bool
+	ChannelOptedIn bool
+	TimeZone       string
+	QuietHours     common.QuietHours
+}
+
+// Reasons a user recipient was not sent to.
+const (
+	SkipReasonOptedOut        = "opted_out"
+	SkipReasonChannelOptedOut = "channel_opted_out"
+	SkipReasonUnknownUser     = "unknown_user"
+	SkipReasonNoAddress       = "no_address"
+	SkipReasonChannelDisabled = "channel_disabled"
+	SkipReasonRerouted        = "rerouted"
+	SkipReasonDeferred        = "deferred"
+	SkipReasonLocalized       = "localized"
+)
+
+// SkippedRecipient is a user that was not sent to. ReroutedTo is the ID of
+// the notification that reaches the user instead, on their preferred channel,
+// after their quiet hours or in their locale.
+type SkippedRecipient struct {
+	UserID     string
+	Reason     string
+	ReroutedTo string
+}
+
+// UserPreference is a user's channel choice for one category.
+type UserPreference struct {
+	PreferredChannel common.NotificationType
+	Disabled         map[common.NotificationType]bool
+}
+
+func (p UserPreference) Enabled(channel common.NotificationType) bool {
+	return !p.Disabled[channel]
+}
@@ -0,0 +1,19 @@
+package models
+
+import "github.com/pdragnev/notification-system/common"
+
+type Template struct {
+	Name      string
+	Version   int
+	Type      common.NotificationType
+	Subject   string
+	Body      string
+	Localized map[string]TemplateVariant
+}
+
+// TemplateVariant is a template's subject and body in one locale. An empty
+// subject falls back to the template's own subject.
+type TemplateVariant struct {
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+}
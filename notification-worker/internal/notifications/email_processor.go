@@ -3,14 +3,17 @@ package notifications
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/pdragnev/notification-system/common"
-	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
 	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 )
 
@@ -18,27 +21,74 @@ type EmailProcessor struct {
 	BaseProcessor
 }
 
-func NewEmailProcessor(userRepo db.UserRepository) *EmailProcessor {
+func NewEmailProcessor(base BaseProcessor) *EmailProcessor {
 	return &EmailProcessor{
-		BaseProcessor: BaseProcessor{UserRepo: userRepo},
+		BaseProcessor: base,
 	}
 }
 
 func (p *EmailProcessor) Process(notificationMsg common.NotificationMessage) error {
+	ctx := context.Background()
 	notification := notificationMsg.Notification
-	userEmails, err := p.UserRepo.GetUserEmailsByIds(context.Background(), notification.To)
-	if err != nil || len(userEmails) == 0 {
-		return fmt.Errorf("failed to fetch user emails: %v", err)
+
+	// Load the files once, every locale gets the same attachments.
+	attachments, err := p.attachments(ctx, notification)
+	if err != nil {
+		return err
+	}
+	return p.SendPerLocale(ctx, notification, func(ctx context.Context, notification common.Notification, locale string) error {
+		return p.send(ctx, notification, locale, attachments)
+	})
+}
+
+// send delivers the notification to recipients that share a locale.
+func (p *EmailProcessor) send(ctx context.Context, notification common.Notification, locale string, attachments []map[string]string) error {
+	content, err := p.RenderContent(ctx, notification, locale)
+	if err != nil {
+		return err
+	}
+
+	emails, err := p.RecipientEmails(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"from_email": notification.From,
+		"subject":    content.Subject,
+		"to":         formatRecipients(emails, notification.Email),
+		"text":       content.Text,
+	}
+	if content.HTML != "" {
+		message["html"] = content.HTML
+	}
+	if options := notification.Email; options != nil {
+		// Without this Mandrill sends every recipient a message addressed to
+		// them alone, and cc addresses would be as hidden as bcc ones.
+		if len(options.Cc) > 0 {
+			message["preserve_recipients"] = true
+		}
+		if options.FromName != "" {
+			message["from_name"] = options.FromName
+		}
+		headers := make(map[string]string, len(options.Headers)+1)
+		for name, value := range options.Headers {
+			headers[name] = value
+		}
+		if options.ReplyTo != "" {
+			headers["Reply-To"] = options.ReplyTo
+		}
+		if len(headers) > 0 {
+			message["headers"] = headers
+		}
+	}
+	if len(attachments) > 0 {
+		message["attachments"] = attachments
 	}
 
 	messagePayload := map[string]interface{}{
-		"key": os.Getenv("MAILCHIMP_API_KEY"),
-		"message": map[string]interface{}{
-			"from_email": notification.From,
-			"subject":    notification.Subject,
-			"text":       notification.Content,
-			"to":         formatRecipients(userEmails),
-		},
+		"key":     os.Getenv("MAILCHIMP_API_KEY"),
+		"message": message,
 	}
 
 	payloadBytes, err := json.Marshal(messagePayload)
@@ -49,6 +99,7 @@ func (p *EmailProcessor) Process(notificationMsg common.NotificationMessage) err
 	// Send the email
 	resp, err := http.Post("https://mandrillapp.com/api/1.0/messages/send", "application/json", bytes.NewBuffer(payloadBytes))
 	if err != nil {
+		metrics.CountProviderError(metrics.ProviderMandrill)
 		return fmt.Errorf("error sending email: %v", err)
 	}
 	defer resp.Body.Close()
@@ -62,26 +113,97 @@ func (p *EmailProcessor) Process(notificationMsg common.NotificationMessage) err
 
 	err = json.Unmarshal(bodyBytes, &response)
 	if err != nil {
+		metrics.CountProviderError(metrics.ProviderMandrill)
 		return fmt.Errorf("error parsing response JSON: %v", err)
 	}
 
-	// Check the response for any rejected or invalid statuses
-	for _, item := range response {
-		if item.Status == "rejected" || item.Status == "invalid" {
-			return fmt.Errorf("email sending failed: %s, reason: %s, id: %s", item.Status, item.RejectReason, item.ID)
-		}
+	if err := checkRejections(response, emails, notification.Email); err != nil {
+		metrics.CountProviderError(metrics.ProviderMandrill)
+		return err
 	}
 	if resp.StatusCode >= 300 {
+		metrics.CountProviderError(metrics.ProviderMandrill)
 		return fmt.Errorf("email sending failed with status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	return nil
 }
 
-func formatRecipients(emails []string) []map[string]string {
-	recipients := make([]map[string]string, len(emails))
-	for i, email := range emails {
-		recipients[i] = map[string]string{"email": email, "type": "to"}
+// checkRejections fails the send when Mandrill rejected a to address. A
+// rejected cc or bcc address is only logged: failing would retry the whole
+// message, and send it again to every to address.
+func checkRejections(response []models.MailchimpEmailResponse, to []string, options *common.EmailOptions) error {
+	copied := make(map[string]bool)
+	if options != nil {
+		for _, email := range append(append([]string(nil), options.Cc...), options.Bcc...) {
+			copied[strings.ToLower(email)] = true
+		}
+	}
+	for _, email := range to {
+		delete(copied, strings.ToLower(email))
+	}
+	for _, item := range response {
+		if item.Status != "rejected" && item.Status != "invalid" {
+			continue
+		}
+		if copied[strings.ToLower(item.Email)] {
+			log.Printf("Mandrill did not send to cc/bcc address %s: %s, reason: %s, id: %s", item.Email, item.Status, item.RejectReason, item.ID)
+			continue
+		}
+		return fmt.Errorf("email sending failed: %s, reason: %s, id: %s", item.Status, item.RejectReason, item.ID)
+	}
+	return nil
+}
+
+// attachments loads the notification's attachments in Mandrill's format.
+func (p *EmailProcessor) attachments(ctx context.Context, notification common.Notification) ([]map[string]string, error) {
+	if notification.Email == nil || len(notification.Email.Attachments) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(notification.Email.Attachments))
+	for i, attachment := range notification.Email.Attachments {
+		ids[i] = attachment.ID
+	}
+	stored, err := p.AttachmentRepo.GetAttachments(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachments: %v", err)
+	}
+
+	attachments := make([]map[string]string, len(ids))
+	for i, attachment := range notification.Email.Attachments {
+		file, ok := stored[strings.ToLower(attachment.ID)]
+		if !ok {
+			return nil, models.NewAttachmentError(fmt.Sprintf("attachment %s not found, it may have expired", attachment.ID))
+		}
+		name := file.Filename
+		if attachment.Filename != "" {
+			name = attachment.Filename
+		}
+		attachments[i] = map[string]string{
+			"type":    file.ContentType,
+			"name":    name,
+			"content": base64.StdEncoding.EncodeToString(file.Content),
+		}
+	}
+	return attachments, nil
+}
+
+// formatRecipients lists the addresses in Mandrill's format, followed by the
+// cc and bcc addresses of the options.
+func formatRecipients(emails []string, options *common.EmailOptions) []map[string]string {
+	recipients := make([]map[string]string, 0, len(emails))
+	for _, email := range emails {
+		recipients = append(recipients, map[string]string{"email": email, "type": "to"})
+	}
+	if options == nil {
+		return recipients
+	}
+	for _, email := range options.Cc {
+		recipients = append(recipients, map[string]string{"email": email, "type": "cc"})
+	}
+	for _, email := range options.Bcc {
+		recipients = append(recipients, map[string]string{"email": email, "type": "bcc"})
 	}
 	return recipients
 }
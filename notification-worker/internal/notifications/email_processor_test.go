@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+func TestFormatRecipients(t *testing.T) {
+	options := &common.EmailOptions{Cc: []string{"cc@example.com"}, Bcc: []string{"bcc@example.com"}}
+	got := formatRecipients([]string{"to@example.com"}, options)
+	want := []map[string]string{
+		{"email": "to@example.com", "type": "to"},
+		{"email": "cc@example.com", "type": "cc"},
+		{"email": "bcc@example.com", "type": "bcc"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formatRecipients() = %v, want %v", got, want)
+	}
+}
+
+func TestCheckRejections(t *testing.T) {
+	options := &common.EmailOptions{Cc: []string{"CC@example.com", "both@example.com"}, Bcc: []string{"bcc@example.com"}}
+	to := []string{"to@example.com", "both@example.com"}
+	tests := []struct {
+		name     string
+		response []models.MailchimpEmailResponse
+		wantErr  bool
+	}{
+		{
+			name:     "all sent",
+			response: []models.MailchimpEmailResponse{{Email: "to@example.com", Status: "sent"}, {Email: "cc@example.com", Status: "queued"}},
+		},
+		{
+			name:     "rejected to address",
+			response: []models.MailchimpEmailResponse{{Email: "to@example.com", Status: "rejected", RejectReason: "hard-bounce"}},
+			wantErr:  true,
+		},
+		{
+			name:     "rejected cc address",
+			response: []models.MailchimpEmailResponse{{Email: "to@example.com", Status: "sent"}, {Email: "cc@example.com", Status: "rejected"}},
+		},
+		{
+			name:     "invalid bcc address",
+			response: []models.MailchimpEmailResponse{{Email: "bcc@example.com", Status: "invalid"}},
+		},
+		{
+			name:     "rejected address that is also a to address",
+			response: []models.MailchimpEmailResponse{{Email: "both@example.com", Status: "rejected"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRejections(tt.response, to, options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRejections() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+// LocaleGroup holds the recipients of a notification that get the same
+// localized content.
+type LocaleGroup struct {
+	// Locale is the variant the group gets, or "" for the default content.
+	Locale       string
+	Notification common.Notification
+}
+
+// SplitByLocale groups the recipients by the variant their locale selects,
+// keeping the order in which each group first appears. A recipient's own
+// locale wins over the one stored for the user. A notification without
+// variants is a single group.
+func (p *BaseProcessor) SplitByLocale(ctx context.Context, notification common.Notification) ([]LocaleGroup, error) {
+	variants, err := p.localeVariants(ctx, notification)
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return []LocaleGroup{{Notification: notification}}, nil
+	}
+
+	locales := map[string]string{}
+	if userIDs, _, _ := common.SplitRecipients(notification.To); len(userIDs) > 0 {
+		locales, err = p.UserRepo.GetUserLocales(ctx, userIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up recipient locales: %v", err)
+		}
+	}
+
+	var groups []LocaleGroup
+	indexes := make(map[string]int)
+	for _, recipient := range notification.To {
+		locale := recipient.Locale
+		if locale == "" && recipient.UserID != "" {
+			locale = locales[strings.ToLower(recipient.UserID)]
+		}
+		variant := common.MatchLocale(locale, variants)
+
+		i, ok := indexes[variant]
+		if !ok {
+			group := notification
+			group.To = nil
+			groups = append(groups, LocaleGroup{Locale: variant, Notification: group})
+			i = len(groups) - 1
+			indexes[variant] = i
+		}
+		groups[i].Notification.To = append(groups[i].Notification.To, recipient)
+	}
+	return groups, nil
+}
+
+// SendPerLocale sends the first locale group of the notification with send
+// and queues every other group as a copy of its own. A failed send is retried
+// as a whole, and sending the groups one after another would repeat the
+// groups that already went out; the copies are queued first and are not
+// queued again on a retry.
+func (p *BaseProcessor) SendPerLocale(ctx context.Context, notification common.Notification, send func(ctx context.Context, notification common.Notification, locale string) error) error {
+	groups, err := p.SplitByLocale(ctx, notification)
+	if err != nil {
+		return err
+	}
+
+	var skipped []models.SkippedRecipient
+	for _, group := range groups[1:] {
+		id, err := p.queueLocaleGroup(ctx, notification, group)
+		if err != nil {
+			return err
+		}
+		userIDs, _, _ := common.SplitRecipients(group.Notification.To)
+		for _, userID := range userIDs {
+			skipped = append(skipped, models.SkippedRecipient{UserID: userID, Reason: models.SkipReasonLocalized, ReroutedTo: id})
+		}
+	}
+	if len(skipped) > 0 {
+		if err := p.StatusRepo.RecordSkippedRecipients(ctx, notification, skipped); err != nil {
+			log.Printf("Failed to record localized recipients for notification %s: %v", notification.ID, err)
+		}
+	}
+
+	return send(ctx, groups[0].Notification, groups[0].Locale)
+}
+
+// queueLocaleGroup queues a copy of the notification for the recipients of
+// one locale group and returns its ID. The copy splits into that one group
+// when the worker receives it.
+func (p *BaseProcessor) queueLocaleGroup(ctx context.Context, notification common.Notification, group LocaleGroup) (string, error) {
+	keys := make([]string, len(group.Notification.To))
+	for i, recipient := range group.Notification.To {
+		keys[i] = recipient.Key()
+	}
+	localized := withoutCopyRecipients(group.Notification)
+	localized.ID = copyID(notification.ID, "locale/"+group.Locale, keys)
+	localized.SendAt = nil
+
+	created, err := p.StatusRepo.CreateNotification(ctx, localized, common.QueuedStatus)
+	if err != nil {
+		return "", fmt.Errorf("failed to record localized notification: %v", err)
+	}
+	if !created {
+		return localized.ID, nil
+	}
+	if err := p.Publisher.PublishNotification(common.NewCopyMessage(localized)); err != nil {
+		return "", fmt.Errorf("failed to publish localized notification: %v", err)
+	}
+	log.Printf("Queued %d recipients of notification %s in locale %q as notification %s", len(keys), notification.ID, group.Locale, localized.ID)
+	return localized.ID, nil
+}
+
+// localeVariants lists the locales the notification, or its template, has
+// content for.
+func (p *BaseProcessor) localeVariants(ctx context.Context, notification common.Notification) ([]string, error) {
+	var variants []string
+	if notification.TemplateID != "" {
+		template, err := p.template(ctx, notification)
+		if err != nil {
+			return nil, err
+		}
+		for locale := range template.Localized {
+			variants = append(variants, locale)
+		}
+	} else {
+		for locale := range notification.Localized {
+			variants = append(variants, locale)
+		}
+	}
+	sort.Strings(variants)
+	return variants, nil
+}
@@ -0,0 +1,149 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type fakeUserRepo struct {
+	locales map[string]string
+}
+
+func (r *fakeUserRepo) GetUserEmailsByIds(ctx context.Context, userIds []string) ([]models.UserContact, error) {
+	return nil, nil
+}
+
+func (r *fakeUserRepo) GetUserPhonesByIds(ctx context.Context, userIds []string) ([]models.UserContact, error) {
+	return nil, nil
+}
+
+func (r *fakeUserRepo) GetUserLocales(ctx context.Context, userIds []string) (map[string]string, error) {
+	return r.locales, nil
+}
+
+const (
+	userBG = "11111111-1111-4111-8111-111111111111"
+	userEN = "22222222-2222-4222-8222-222222222222"
+	userDE = "33333333-3333-4333-8333-333333333333"
+)
+
+func localizedNotification(to ...common.Recipient) common.Notification {
+	return common.Notification{
+		ID:      "5b0f4c1e-8f2a-4a8e-9c36-0d6a1c2b7e11",
+		Type:    common.EmailNotificationType,
+		To:      to,
+		From:    "noreply@example.com",
+		Subject: "Hello",
+		Content: "Hi",
+		Localized: map[string]common.LocalizedContent{
+			"bg": {Content: "Здравей"},
+			"en": {Content: "Hi"},
+		},
+	}
+}
+
+func TestSplitByLocale(t *testing.T) {
+	users := &fakeUserRepo{locales: map[string]string{userBG: "bg-BG", userEN: "en", userDE: "de"}}
+	tests := []struct {
+		name string
+		to   []common.Recipient
+		want map[string][]string
+	}{
+		{
+			name: "stored locales",
+			to:   []common.Recipient{common.UserRecipient(userBG), common.UserRecipient(userEN), common.UserRecipient(userDE)},
+			want: map[string][]string{"bg": {"user:" + userBG}, "en": {"user:" + userEN}, "": {"user:" + userDE}},
+		},
+		{
+			name: "recipient override",
+			to:   []common.Recipient{{UserID: userBG, Locale: "en-GB"}, common.UserRecipient(userEN)},
+			want: map[string][]string{"en": {"user:" + userBG, "user:" + userEN}},
+		},
+		{
+			name: "literal addresses",
+			to:   []common.Recipient{{Email: "a@example.com"}, {Email: "b@example.com", Locale: "BG"}},
+			want: map[string][]string{"": {"email:a@example.com"}, "bg": {"email:b@example.com"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &BaseProcessor{UserRepo: users}
+			groups, err := p.SplitByLocale(context.Background(), localizedNotification(tt.to...))
+			if err != nil {
+				t.Fatalf("SplitByLocale() failed: %v", err)
+			}
+			got := make(map[string][]string)
+			for _, group := range groups {
+				for _, recipient := range group.Notification.To {
+					got[group.Locale] = append(got[group.Locale], recipient.Key())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitByLocaleWithoutVariants(t *testing.T) {
+	notification := localizedNotification(common.UserRecipient(userBG))
+	notification.Localized = nil
+	groups, err := (&BaseProcessor{}).SplitByLocale(context.Background(), notification)
+	if err != nil || len(groups) != 1 || groups[0].Locale != "" {
+		t.Errorf("SplitByLocale() = %v, %v, want one default group", groups, err)
+	}
+}
+
+// A failed send must not repeat the groups queued before it on retry.
+func TestSendPerLocaleRetry(t *testing.T) {
+	publisher := &fakePublisher{}
+	p := &BaseProcessor{
+		UserRepo:   &fakeUserRepo{locales: map[string]string{userBG: "bg", userEN: "en"}},
+		StatusRepo: &fakeStatusRepo{},
+		Publisher:  publisher,
+	}
+	notification := localizedNotification(common.UserRecipient(userBG), common.UserRecipient(userEN), common.UserRecipient(userDE))
+
+	var sent []string
+	failing := errors.New("provider down")
+	send := func(ctx context.Context, group common.Notification, locale string) error {
+		sent = append(sent, locale)
+		if len(sent) == 1 {
+			return failing
+		}
+		return nil
+	}
+
+	if err := p.SendPerLocale(context.Background(), notification, send); !errors.Is(err, failing) {
+		t.Fatalf("first attempt = %v, want the send error", err)
+	}
+	if err := p.SendPerLocale(context.Background(), notification, send); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(sent, []string{"bg", "bg"}) {
+		t.Errorf("sent locales = %q, want only the first group twice", sent)
+	}
+	if len(publisher.published) != 2 {
+		t.Fatalf("published %d copies, want 2", len(publisher.published))
+	}
+	for _, message := range publisher.published {
+		if len(message.Notification.To) != 1 || message.Notification.ID == notification.ID {
+			t.Errorf("copy %s has recipients %v", message.Notification.ID, message.Notification.To)
+		}
+	}
+}
+
+func TestUserRecipientsKeepsOverrides(t *testing.T) {
+	notification := localizedNotification(common.Recipient{UserID: userBG, Locale: "en"}, common.UserRecipient(userEN))
+	got := userRecipients(notification, []string{userEN, userBG, userDE})
+	want := []common.Recipient{common.UserRecipient(userEN), {UserID: userBG, Locale: "en"}, common.UserRecipient(userDE)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("userRecipients() = %v, want %v", got, want)
+	}
+}
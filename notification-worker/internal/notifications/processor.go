@@ -1,26 +1,414 @@
 package notifications
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/pdragnev/notification-system/common"
 	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 )
 
 type Processor interface {
 	Process(notificationMsg common.NotificationMessage) error
 }
 
+// Publisher queues notifications, such as the copies sent to recipients who
+// prefer another channel or are in their quiet hours.
+type Publisher interface {
+	PublishNotification(notificationMsg common.NotificationMessage) error
+	PublishNotificationAfter(notificationMsg common.NotificationMessage, delay time.Duration) error
+}
+
 type BaseProcessor struct {
-	UserRepo db.UserRepository
+	UserRepo       db.UserRepository
+	TemplateRepo   db.TemplateRepository
+	StatusRepo     db.NotificationStatusRepository
+	PreferenceRepo db.PreferenceRepository
+	AttachmentRepo db.AttachmentRepository
+	Publisher      Publisher
+}
+
+// Content is what a notification sends. Text is always set, HTML only for
+// email notifications that have an HTML body.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// RenderContent returns the content to send in the given locale variant, or
+// the default content when locale is "". A notification that references a
+// template is rendered from it, any other notification is sent with its own
+// content. When only HTML is given, the text is generated from it.
+func (p *BaseProcessor) RenderContent(ctx context.Context, notification common.Notification, locale string) (Content, error) {
+	if notification.TemplateID == "" {
+		content := Content{Subject: notification.Subject, Text: notification.Content}
+		if notification.Email != nil {
+			content.HTML = notification.Email.HTML
+		}
+		// A variant replaces both bodies, the default HTML is in the wrong language.
+		if variant, ok := notification.Localized[locale]; ok && locale != "" {
+			content.Text, content.HTML = variant.Content, variant.HTML
+			if variant.Subject != "" {
+				content.Subject = variant.Subject
+			}
+		}
+		if content.Text == "" && content.HTML != "" {
+			content.Text = common.HTMLToText(content.HTML)
+		}
+		return content, nil
+	}
+
+	template, err := p.template(ctx, notification)
+	if err != nil {
+		return Content{}, err
+	}
+	subject, body := template.Subject, template.Body
+	if variant, ok := template.Localized[locale]; ok && locale != "" {
+		body = variant.Body
+		if variant.Subject != "" {
+			subject = variant.Subject
+		}
+	}
+
+	rendered, err := common.RenderTemplate(template.Type, subject, body, notification.Data)
+	if err != nil {
+		return Content{}, models.NewTemplateError(fmt.Sprintf("error rendering template %s: %v", template.Name, err))
+	}
+	if template.Type == common.EmailNotificationType {
+		// Email templates render to escaped HTML.
+		return Content{Subject: rendered.Subject, Text: common.HTMLToText(rendered.Body), HTML: rendered.Body}, nil
+	}
+	return Content{Subject: rendered.Subject, Text: rendered.Body}, nil
+}
+
+func (p *BaseProcessor) template(ctx context.Context, notification common.Notification) (*models.Template, error) {
+	template, err := p.TemplateRepo.GetTemplate(ctx, notification.TemplateID, notification.TemplateVersion)
+	if errors.Is(err, db.ErrTemplateNotFound) {
+		return nil, models.NewTemplateError(fmt.Sprintf("template %s version %d not found", notification.TemplateID, notification.TemplateVersion))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if template.Type != notification.Type {
+		return nil, models.NewTemplateError(fmt.Sprintf("template %s is for %s notifications, not %s", template.Name, template.Type, notification.Type))
+	}
+	return template, nil
+}
+
+// RecipientEmails merges the emails of the referenced users with the literal
+// email recipients, dropping duplicates and users that may not be sent to.
+func (p *BaseProcessor) RecipientEmails(ctx context.Context, notification common.Notification) ([]string, error) {
+	userIDs, emails, _ := common.SplitRecipients(notification.To)
+	addresses, err := p.resolveUsers(ctx, notification, userIDs, p.UserRepo.GetUserEmailsByIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve recipient emails: %v", err)
+	}
+	return requireRecipients(notification, mergeAddresses(addresses, emails, strings.ToLower))
+}
+
+// RecipientPhones merges the phone numbers of the referenced users with the
+// literal phone recipients, dropping duplicates and users that may not be
+// sent to.
+func (p *BaseProcessor) RecipientPhones(ctx context.Context, notification common.Notification) ([]string, error) {
+	userIDs, _, phones := common.SplitRecipients(notification.To)
+	addresses, err := p.resolveUsers(ctx, notification, userIDs, p.UserRepo.GetUserPhonesByIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve recipient phone numbers: %v", err)
+	}
+	return requireRecipients(notification, mergeAddresses(addresses, phones, strings.TrimSpace))
+}
+
+// resolveUsers looks up the users' addresses and records the users that are
+// skipped: unknown users, users without an address on this channel, users who
+// turned this channel off for the category and, unless the notification is
+// transactional, users who opted out. Users who turned the channel off but
+// prefer another one are rerouted to it where possible, and unless the
+// notification is critical, users in their quiet hours are deferred until the
+// quiet hours end.
+func (p *BaseProcessor) resolveUsers(ctx context.Context, notification common.Notification, userIDs []string, lookup func(context.Context, []string) ([]models.UserContact, error)) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	contacts, err := lookup(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := p.PreferenceRepo.GetPreferences(ctx, userIDs, notification.Category.OrDefault())
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]models.UserContact, len(contacts))
+	for _, contact := range contacts {
+		byID[strings.ToLower(contact.UserID)] = contact
+	}
+
+	requiresConsent := notification.Category.RequiresConsent()
+	honorsQuietHours := notification.Priority != common.CriticalPriority && p.Publisher != nil
+	now := time.Now()
+	var addresses []string
+	var skipped []models.SkippedRecipient
+	reroutes := make(map[common.NotificationType][]string)
+	deferrals := make(map[time.Time][]string)
+	for _, id := range userIDs {
+		contact, ok := byID[strings.ToLower(id)]
+		preference := preferences[strings.ToLower(id)]
+		reason := ""
+		switch {
+		case !ok:
+			reason = models.SkipReasonUnknownUser
+		case requiresConsent && !contact.OptedIn:
+			reason = models.SkipReasonOptedOut
+		case !preference.Enabled(notification.Type):
+			target := preference.PreferredChannel
+			if target != "" && target != notification.Type && preference.Enabled(target) {
+				reroutes[target] = append(reroutes[target], id)
+				continue
+			}
+			reason = models.SkipReasonChannelDisabled
+		case contact.Address == "":
+			reason = models.SkipReasonNoAddress
+		case requiresConsent && !contact.ChannelOptedIn:
+			reason = models.SkipReasonChannelOptedOut
+		}
+		if reason != "" {
+			skipped = append(skipped, models.SkippedRecipient{UserID: id, Reason: reason})
+			continue
+		}
+		if honorsQuietHours {
+			if until, quiet := contact.QuietHours.Until(now, contact.TimeZone); quiet {
+				until = until.UTC()
+				deferrals[until] = append(deferrals[until], id)
+				continue
+			}
+		}
+		addresses = append(addresses, contact.Address)
+	}
+
+	for until, ids := range deferrals {
+		deferred, err := p.deferUntil(ctx, notification, until, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			skipped = append(skipped, models.SkippedRecipient{UserID: id, Reason: models.SkipReasonDeferred, ReroutedTo: deferred})
+		}
+	}
+
+	for _, target := range common.NotificationTypes {
+		ids := reroutes[target]
+		if len(ids) == 0 {
+			continue
+		}
+		rerouted, err := p.reroute(ctx, notification, target, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if rerouted == "" {
+				skipped = append(skipped, models.SkippedRecipient{UserID: id, Reason: models.SkipReasonChannelDisabled})
+			} else {
+				skipped = append(skipped, models.SkippedRecipient{UserID: id, Reason: models.SkipReasonRerouted, ReroutedTo: rerouted})
+			}
+		}
+	}
+
+	if len(skipped) > 0 {
+		log.Printf("Skipping %d of %d users for notification %s", len(skipped), len(userIDs), notification.ID)
+		if err := p.StatusRepo.RecordSkippedRecipients(ctx, notification, skipped); err != nil {
+			log.Printf("Failed to record skipped recipients for notification %s: %v", notification.ID, err)
+		}
+	}
+	return addresses, nil
+}
+
+// reroute queues a copy of the notification on the target channel for the
+// given users and returns its ID. It returns an empty ID when the notification
+// cannot be sent on that channel: template content is channel specific, and
+// the copy needs a default sender and must pass the target's validation.
+func (p *BaseProcessor) reroute(ctx context.Context, notification common.Notification, target common.NotificationType, userIDs []string) (string, error) {
+	if notification.TemplateID != "" || p.Publisher == nil {
+		return "", nil
+	}
+	from := defaultSender(target)
+	if from == "" {
+		return "", nil
+	}
+
+	// The copy's ID is derived from the original, so a retry of the original
+	// finds the copy it already queued.
+	rerouted := notification
+	rerouted.ID = copyID(notification.ID, string(target), userIDs)
+	rerouted.Type = target
+	rerouted.From = from
+	rerouted.SendAt = nil
+	if target != common.EmailNotificationType {
+		rerouted = withoutEmailOptions(rerouted)
+	}
+	rerouted = withoutCopyRecipients(rerouted)
+	rerouted.To = userRecipients(notification, userIDs)
+	if errs := common.ValidateNotification(rerouted); errs != nil {
+		log.Printf("Not rerouting notification %s to %s: %v", notification.ID, target, errs)
+		return "", nil
+	}
+
+	// Record the copy before publishing it, so the API can report it and the
+	// worker's status updates never run ahead of its creation.
+	created, err := p.StatusRepo.CreateNotification(ctx, rerouted, common.QueuedStatus)
+	if err != nil {
+		return "", fmt.Errorf("failed to record rerouted notification: %v", err)
+	}
+	if !created {
+		return rerouted.ID, nil
+	}
+	if err := p.Publisher.PublishNotification(common.NewCopyMessage(rerouted)); err != nil {
+		return "", fmt.Errorf("failed to publish rerouted notification: %v", err)
+	}
+	log.Printf("Rerouted %d users of notification %s to %s notification %s", len(userIDs), notification.ID, target, rerouted.ID)
+	return rerouted.ID, nil
+}
+
+// deferUntil queues a copy of the notification for the given users that the
+// worker receives once their quiet hours end, and returns its ID.
+func (p *BaseProcessor) deferUntil(ctx context.Context, notification common.Notification, until time.Time, userIDs []string) (string, error) {
+	deferred := notification
+	deferred.ID = copyID(notification.ID, fmt.Sprintf("deferred/%d", until.Unix()), userIDs)
+	deferred.SendAt = nil
+	deferred = withoutCopyRecipients(deferred)
+	deferred.To = userRecipients(notification, userIDs)
+
+	created, err := p.StatusRepo.CreateNotification(ctx, deferred, common.DeferredStatus)
+	if err != nil {
+		return "", fmt.Errorf("failed to record deferred notification: %v", err)
+	}
+	if !created {
+		return deferred.ID, nil
+	}
+	if err := p.Publisher.PublishNotificationAfter(common.NewCopyMessage(deferred), time.Until(until)); err != nil {
+		return "", fmt.Errorf("failed to publish deferred notification: %v", err)
+	}
+	log.Printf("Deferred %d users of notification %s until %s as notification %s", len(userIDs), notification.ID, until.Format(time.RFC3339), deferred.ID)
+	return deferred.ID, nil
+}
+
+// userRecipients returns the notification's recipients for the given users,
+// keeping what the request set on them, such as their locale.
+func userRecipients(notification common.Notification, userIDs []string) []common.Recipient {
+	byID := make(map[string]common.Recipient, len(notification.To))
+	for _, recipient := range notification.To {
+		if recipient.UserID != "" {
+			byID[strings.ToLower(recipient.UserID)] = recipient
+		}
+	}
+	recipients := make([]common.Recipient, len(userIDs))
+	for i, id := range userIDs {
+		recipient, ok := byID[strings.ToLower(id)]
+		if !ok {
+			recipient = common.UserRecipient(id)
+		}
+		recipients[i] = recipient
+	}
+	return recipients
+}
+
+// withoutCopyRecipients drops the cc and bcc addresses from a copy the worker
+// makes of a notification. They get the message sent for the original, and
+// would otherwise receive it once more for every copy.
+func withoutCopyRecipients(notification common.Notification) common.Notification {
+	if notification.Email == nil || len(notification.Email.Cc)+len(notification.Email.Bcc) == 0 {
+		return notification
+	}
+	options := *notification.Email
+	options.Cc = nil
+	options.Bcc = nil
+	notification.Email = &options
+	return notification
+}
+
+// withoutEmailOptions drops the email-only fields of a notification copied to
+// another channel. Bodies that were only given as HTML are kept as text.
+func withoutEmailOptions(notification common.Notification) common.Notification {
+	if notification.Email != nil && notification.Content == "" {
+		notification.Content = common.HTMLToText(notification.Email.HTML)
+	}
+	notification.Email = nil
+	if len(notification.Localized) > 0 {
+		localized := make(map[string]common.LocalizedContent, len(notification.Localized))
+		for locale, variant := range notification.Localized {
+			if variant.Content == "" {
+				variant.Content = common.HTMLToText(variant.HTML)
+			}
+			variant.HTML = ""
+			localized[locale] = variant
+		}
+		notification.Localized = localized
+	}
+	return notification
+}
+
+// copyID derives the ID of a copy the worker queues for some users of a
+// notification. The users are part of it because a notification sent per
+// locale can queue one copy of each kind for every locale.
+func copyID(notificationID string, kind string, userIDs []string) string {
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = strings.ToLower(id)
+	}
+	sort.Strings(ids)
+	name := fmt.Sprintf("notification:%s/%s/%s", notificationID, kind, strings.Join(ids, ","))
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
+
+// defaultSender returns the From used for notifications rerouted to a channel.
+func defaultSender(notificationType common.NotificationType) string {
+	switch notificationType {
+	case common.EmailNotificationType:
+		return os.Getenv("DEFAULT_EMAIL_FROM")
+	case common.SmsNotificationType:
+		return os.Getenv("DEFAULT_SMS_FROM")
+	default:
+		return ""
+	}
+}
+
+func requireRecipients(notification common.Notification, addresses []string) ([]string, error) {
+	if len(addresses) == 0 {
+		return nil, models.NewNoRecipientsError(fmt.Sprintf("no recipient of notification %s can be sent to", notification.ID))
+	}
+	return addresses, nil
+}
+
+func mergeAddresses(lookedUp []string, literal []string, normalize func(string) string) []string {
+	seen := make(map[string]bool, len(lookedUp)+len(literal))
+	var merged []string
+	for _, addresses := range [][]string{lookedUp, literal} {
+		for _, address := range addresses {
+			key := normalize(address)
+			if address == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, address)
+		}
+	}
+	return merged
 }
 
-func GetProcessorForType(notificationType string, userRepo db.UserRepository) (Processor, error) {
+func GetProcessorForType(notificationType string, base BaseProcessor) (Processor, error) {
 	switch notificationType {
 	case "email":
-		return NewEmailProcessor(userRepo), nil
+		return NewEmailProcessor(base), nil
 	case "sms":
-		return NewSmsProcessor(userRepo), nil
+		return NewSmsProcessor(base), nil
 	default:
 		return nil, fmt.Errorf("unknown notification type: %s", notificationType)
 	}
@@ -0,0 +1,143 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type fakeStatusRepo struct {
+	created map[string]common.NotificationStatus
+}
+
+func (r *fakeStatusRepo) UpdateStatus(ctx context.Context, notification common.Notification, status common.NotificationStatus, retryCount int, lastError string) error {
+	return nil
+}
+
+func (r *fakeStatusRepo) CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) (bool, error) {
+	if r.created == nil {
+		r.created = make(map[string]common.NotificationStatus)
+	}
+	if _, ok := r.created[notification.ID]; ok {
+		return false, nil
+	}
+	r.created[notification.ID] = status
+	return true, nil
+}
+
+func (r *fakeStatusRepo) RecordSkippedRecipients(ctx context.Context, notification common.Notification, skipped []models.SkippedRecipient) error {
+	return nil
+}
+
+type fakePublisher struct {
+	published []common.NotificationMessage
+	delays    []time.Duration
+}
+
+func (p *fakePublisher) PublishNotification(notificationMsg common.NotificationMessage) error {
+	p.published = append(p.published, notificationMsg)
+	p.delays = append(p.delays, 0)
+	return nil
+}
+
+func (p *fakePublisher) PublishNotificationAfter(notificationMsg common.NotificationMessage, delay time.Duration) error {
+	p.published = append(p.published, notificationMsg)
+	p.delays = append(p.delays, delay)
+	return nil
+}
+
+func testNotification() common.Notification {
+	return common.Notification{
+		ID:        "5b0f4c1e-8f2a-4a8e-9c36-0d6a1c2b7e11",
+		Type:      common.EmailNotificationType,
+		To:        []common.Recipient{common.UserRecipient("563c1e2a-0b3e-4a55-9b0e-0d6a1c2b7e11")},
+		From:      "noreply@example.com",
+		Subject:   "Hello",
+		Content:   "Hi there",
+		APIKeyID:  "key-a",
+		DedupeKey: "order-1042",
+	}
+}
+
+// Copies must not carry the original's dedupe key, or the worker drops them
+// as duplicates of the original.
+func TestCopiesGetTheirOwnDedupeKey(t *testing.T) {
+	t.Setenv("DEFAULT_SMS_FROM", "+15551234567")
+	original := testNotification()
+	originalKey := common.NewNotificationMessage(original).DedupeKey
+	userIDs := []string{"563c1e2a-0b3e-4a55-9b0e-0d6a1c2b7e11"}
+
+	tests := []struct {
+		name  string
+		queue func(p *BaseProcessor) (string, error)
+	}{
+		{"reroute", func(p *BaseProcessor) (string, error) {
+			return p.reroute(context.Background(), original, common.SmsNotificationType, userIDs)
+		}},
+		{"defer", func(p *BaseProcessor) (string, error) {
+			return p.deferUntil(context.Background(), original, time.Now().Add(time.Hour), userIDs)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &fakePublisher{}
+			p := &BaseProcessor{StatusRepo: &fakeStatusRepo{}, Publisher: publisher}
+
+			id, err := tt.queue(p)
+			if err != nil {
+				t.Fatalf("queueing the copy failed: %v", err)
+			}
+			if len(publisher.published) != 1 {
+				t.Fatalf("published %d messages, want 1", len(publisher.published))
+			}
+			message := publisher.published[0]
+			if message.Notification.ID != id || id == original.ID {
+				t.Errorf("copy ID = %q, returned %q, original %q", message.Notification.ID, id, original.ID)
+			}
+			if message.DedupeKey == originalKey || message.DedupeKey == common.DedupeKeyFor(original) {
+				t.Errorf("copy shares the original's dedupe key %q", message.DedupeKey)
+			}
+			if message.Notification.DedupeKey != "" {
+				t.Errorf("copy kept the caller's dedupe key %q", message.Notification.DedupeKey)
+			}
+
+			// A retry of the original finds the copy instead of queueing another.
+			again, err := tt.queue(p)
+			if err != nil || again != id || len(publisher.published) != 1 {
+				t.Errorf("retry returned %q, %v and published %d messages", again, err, len(publisher.published))
+			}
+		})
+	}
+}
+
+// Cc and bcc addresses get the original's message, so copies go without them.
+func TestCopiesDropCcAndBcc(t *testing.T) {
+	original := testNotification()
+	original.Email = &common.EmailOptions{Cc: []string{"cc@example.com"}, Bcc: []string{"bcc@example.com"}, FromName: "Shop"}
+	publisher := &fakePublisher{}
+	p := &BaseProcessor{StatusRepo: &fakeStatusRepo{}, Publisher: publisher}
+
+	if _, err := p.deferUntil(context.Background(), original, time.Now().Add(time.Hour), []string{"563c1e2a-0b3e-4a55-9b0e-0d6a1c2b7e11"}); err != nil {
+		t.Fatalf("deferUntil() failed: %v", err)
+	}
+	if _, err := p.queueLocaleGroup(context.Background(), original, LocaleGroup{Locale: "bg", Notification: original}); err != nil {
+		t.Fatalf("queueLocaleGroup() failed: %v", err)
+	}
+
+	for _, message := range publisher.published {
+		options := message.Notification.Email
+		if options == nil || options.FromName != "Shop" {
+			t.Errorf("copy %s lost its email options: %+v", message.Notification.ID, options)
+			continue
+		}
+		if len(options.Cc) > 0 || len(options.Bcc) > 0 {
+			t.Errorf("copy %s kept cc %v and bcc %v", message.Notification.ID, options.Cc, options.Bcc)
+		}
+	}
+	if len(original.Email.Cc) != 1 || len(original.Email.Bcc) != 1 {
+		t.Errorf("original lost its cc or bcc: %+v", original.Email)
+	}
+}
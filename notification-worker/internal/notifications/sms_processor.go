@@ -2,12 +2,11 @@ package notifications
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
 
 	"github.com/pdragnev/notification-system/common"
-	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
 	"github.com/twilio/twilio-go"
 	api "github.com/twilio/twilio-go/rest/api/v2010"
 )
@@ -17,33 +16,43 @@ type SmsProcessor struct {
 	client *twilio.RestClient
 }
 
-func NewSmsProcessor(userRepo db.UserRepository) *SmsProcessor {
+func NewSmsProcessor(base BaseProcessor) *SmsProcessor {
 	param := twilio.ClientParams{
 		Username: os.Getenv("TWILIO_ACC_SID"),
 		Password: os.Getenv("TWILIO_AUTH_TOKEN"),
 	}
 	client := twilio.NewRestClientWithParams(param)
 	return &SmsProcessor{
-		BaseProcessor: BaseProcessor{UserRepo: userRepo},
+		BaseProcessor: base,
 		client:        client,
 	}
 }
 
 func (p *SmsProcessor) Process(notificationMsg common.NotificationMessage) error {
-	notification := notificationMsg.Notification
-	userPhoneNumbers, err := p.UserRepo.GetUserPhonesByIds(context.Background(), notification.To)
-	if err != nil || len(userPhoneNumbers) == 0 {
-		return fmt.Errorf("failed to fetch user phone numbers: %v", err)
+	return p.SendPerLocale(context.Background(), notificationMsg.Notification, p.send)
+}
+
+// send delivers the notification to recipients that share a locale.
+func (p *SmsProcessor) send(ctx context.Context, notification common.Notification, locale string) error {
+	content, err := p.RenderContent(ctx, notification, locale)
+	if err != nil {
+		return err
+	}
+
+	phoneNumbers, err := p.RecipientPhones(ctx, notification)
+	if err != nil {
+		return err
 	}
 
 	params := &api.CreateMessageParams{}
-	params.SetBody(notification.Content)
+	params.SetBody(content.Text)
 	params.SetFrom(notification.From)
 
-	for i := 0; i < len(userPhoneNumbers); i++ {
-		params.SetTo(userPhoneNumbers[i])
+	for i := 0; i < len(phoneNumbers); i++ {
+		params.SetTo(phoneNumbers[i])
 		resp, err := p.client.Api.CreateMessage(params)
 		if err != nil {
+			metrics.CountProviderError(metrics.ProviderTwilio)
 			return err
 		} else {
 			if resp.Sid != nil {
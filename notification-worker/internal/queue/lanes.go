@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"github.com/pdragnev/notification-system/common"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// laneWeights sets how many deliveries each lane gets per round when every
+// lane has work waiting. Lower lanes keep a share so they are never starved.
+var laneWeights = map[common.Priority]int{
+	common.CriticalPriority: 8,
+	common.HighPriority:     4,
+	common.NormalPriority:   2,
+	common.LowPriority:      1,
+}
+
+type lane struct {
+	priority common.Priority
+	weight   int
+	current  int
+	msgs     <-chan amqp091.Delivery
+}
+
+// laneDispatcher picks the next delivery with smooth weighted round robin.
+// The lane chosen for a turn is tried first; when it is empty the other
+// lanes are tried from the most urgent down, so idle lanes never cost time.
+type laneDispatcher struct {
+	lanes []*lane
+}
+
+func newLaneDispatcher(lanes []*lane) *laneDispatcher {
+	return &laneDispatcher{lanes: lanes}
+}
+
+// next blocks until a delivery is available. It returns false once every
+// lane's delivery channel has been closed.
+func (ld *laneDispatcher) next() (amqp091.Delivery, bool) {
+	if preferred := ld.pick(); preferred != nil {
+		if d, ok := ld.tryReceive(preferred); ok {
+			return d, true
+		}
+	}
+	for _, l := range ld.lanes {
+		if d, ok := ld.tryReceive(l); ok {
+			return d, true
+		}
+	}
+	return ld.waitAny()
+}
+
+func (ld *laneDispatcher) pick() *lane {
+	total := 0
+	var best *lane
+	for _, l := range ld.lanes {
+		if l.msgs == nil {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if best == nil || l.current > best.current {
+			best = l
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+func (ld *laneDispatcher) tryReceive(l *lane) (amqp091.Delivery, bool) {
+	if l.msgs == nil {
+		return amqp091.Delivery{}, false
+	}
+	select {
+	case d, ok := <-l.msgs:
+		if !ok {
+			l.msgs = nil
+			return amqp091.Delivery{}, false
+		}
+		return d, true
+	default:
+		return amqp091.Delivery{}, false
+	}
+}
+
+func (ld *laneDispatcher) waitAny() (amqp091.Delivery, bool) {
+	for {
+		open := 0
+		for _, l := range ld.lanes {
+			if l.msgs != nil {
+				open++
+			}
+		}
+		if open == 0 {
+			return amqp091.Delivery{}, false
+		}
+
+		// One case per lane; a nil channel never becomes ready.
+		var critical, high, normal, low <-chan amqp091.Delivery
+		for _, l := range ld.lanes {
+			switch l.priority {
+			case common.CriticalPriority:
+				critical = l.msgs
+			case common.HighPriority:
+				high = l.msgs
+			case common.NormalPriority:
+				normal = l.msgs
+			case common.LowPriority:
+				low = l.msgs
+			}
+		}
+
+		var d amqp091.Delivery
+		var ok bool
+		var from common.Priority
+		select {
+		case d, ok = <-critical:
+			from = common.CriticalPriority
+		case d, ok = <-high:
+			from = common.HighPriority
+		case d, ok = <-normal:
+			from = common.NormalPriority
+		case d, ok = <-low:
+			from = common.LowPriority
+		}
+		if ok {
+			return d, true
+		}
+		for _, l := range ld.lanes {
+			if l.priority == from {
+				l.msgs = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// testLanes returns a lane per priority with the given number of deliveries
+// waiting, and the channels to feed them. Each delivery's body is its lane.
+func testLanes(waiting map[common.Priority]int) ([]*lane, map[common.Priority]chan amqp091.Delivery) {
+	var lanes []*lane
+	channels := make(map[common.Priority]chan amqp091.Delivery)
+	for _, priority := range common.Priorities {
+		msgs := make(chan amqp091.Delivery, 100)
+		for i := 0; i < waiting[priority]; i++ {
+			msgs <- amqp091.Delivery{Body: []byte(priority)}
+		}
+		channels[priority] = msgs
+		lanes = append(lanes, &lane{priority: priority, weight: laneWeights[priority], msgs: msgs})
+	}
+	return lanes, channels
+}
+
+func TestLaneDispatcherShares(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiting map[common.Priority]int
+		take    int
+		want    map[common.Priority]int
+	}{
+		{
+			"every lane busy gets its weight",
+			map[common.Priority]int{common.CriticalPriority: 50, common.HighPriority: 50, common.NormalPriority: 50, common.LowPriority: 50},
+			30,
+			map[common.Priority]int{common.CriticalPriority: 16, common.HighPriority: 8, common.NormalPriority: 4, common.LowPriority: 2},
+		},
+		{
+			"idle lanes' turns go to the most urgent busy lane",
+			map[common.Priority]int{common.NormalPriority: 50, common.LowPriority: 50},
+			30,
+			map[common.Priority]int{common.NormalPriority: 28, common.LowPriority: 2},
+		},
+		{
+			"a lone lane gets every turn",
+			map[common.Priority]int{common.LowPriority: 5},
+			5,
+			map[common.Priority]int{common.LowPriority: 5},
+		},
+		{
+			"a drained lane stops taking turns",
+			map[common.Priority]int{common.CriticalPriority: 2, common.LowPriority: 10},
+			12,
+			map[common.Priority]int{common.CriticalPriority: 2, common.LowPriority: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lanes, _ := testLanes(tt.waiting)
+			dispatcher := newLaneDispatcher(lanes)
+			got := make(map[common.Priority]int)
+			for i := 0; i < tt.take; i++ {
+				d, ok := dispatcher.next()
+				if !ok {
+					t.Fatalf("next returned no delivery after %d", i)
+				}
+				got[common.Priority(d.Body)]++
+			}
+			for _, priority := range common.Priorities {
+				if got[priority] != tt.want[priority] {
+					t.Errorf("%s lane got %d deliveries, want %d", priority, got[priority], tt.want[priority])
+				}
+			}
+		})
+	}
+}
+
+func TestLaneDispatcherWaits(t *testing.T) {
+	lanes, channels := testLanes(nil)
+	dispatcher := newLaneDispatcher(lanes)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		channels[common.LowPriority] <- amqp091.Delivery{Body: []byte(common.LowPriority)}
+	}()
+	d, ok := dispatcher.next()
+	if !ok || common.Priority(d.Body) != common.LowPriority {
+		t.Fatalf("next = %q, %v, want a low priority delivery", d.Body, ok)
+	}
+}
+
+func TestLaneDispatcherClosedLanes(t *testing.T) {
+	lanes, channels := testLanes(map[common.Priority]int{common.HighPriority: 1})
+	dispatcher := newLaneDispatcher(lanes)
+
+	close(channels[common.CriticalPriority])
+	close(channels[common.NormalPriority])
+	close(channels[common.LowPriority])
+	if d, ok := dispatcher.next(); !ok || common.Priority(d.Body) != common.HighPriority {
+		t.Fatalf("next = %q, %v, want the waiting high priority delivery", d.Body, ok)
+	}
+
+	close(channels[common.HighPriority])
+	done := make(chan bool)
+	go func() {
+		_, ok := dispatcher.next()
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("next returned a delivery after every lane was closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next blocked after every lane was closed")
+	}
+}
@@ -3,18 +3,30 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/common/rabbitmq"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
 	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 	"github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	consumerRetryDelay = 2 * time.Second
+	// delayQueueExpiryGrace keeps a delay queue around long enough for its
+	// last message to expire into the lane before the queue is deleted.
+	delayQueueExpiryGrace = time.Minute
+)
+
 func init() {
 	if os.Getenv("APP_ENV") == "development" {
 		if err := godotenv.Load(); err != nil {
@@ -25,12 +37,14 @@ func init() {
 
 type RabbitMQConfig struct {
 	URL               string
+	DLXExchange       string
+	DLXQueue          string
 	NotificationQueue string
 }
 
 type RabbitMQClient struct {
-	Connection *amqp091.Connection
-	config     *RabbitMQConfig
+	connections *rabbitmq.ConnectionManager
+	config      *RabbitMQConfig
 }
 
 func NewRabbitMQClient(config RabbitMQConfig) (*RabbitMQClient, error) {
@@ -41,38 +55,54 @@ func NewRabbitMQClient(config RabbitMQConfig) (*RabbitMQClient, error) {
 		return nil, fmt.Errorf("NotificationQueue name must not be empty")
 	}
 
-	conn, err := amqp091.Dial(config.URL)
+	client := &RabbitMQClient{config: &config}
+	// The lanes may have been lost with the broker, so declare them again on
+	// every reconnect before consuming resumes. Delay queues are declared on
+	// every publish and need no help.
+	connections, err := rabbitmq.NewConnectionManager(config.URL, client.declareTopology)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+		return nil, err
 	}
-	return &RabbitMQClient{
-		Connection: conn,
-		config:     &config,
-	}, nil
+	client.connections = connections
+	return client, nil
 }
 
-func (client *RabbitMQClient) StartConsuming(handler func(amqp091.Delivery) error) {
-	ch, err := client.Connection.Channel()
+// SetupQueues declares the queues the worker consumes from, so it can start
+// before the API has declared them.
+func (client *RabbitMQClient) SetupQueues() error {
+	conn, err := client.connections.Connection()
 	if err != nil {
-		log.Fatalf("Failed to open a channel: %v", err)
+		return err
 	}
-	defer ch.Close()
+	return client.declareTopology(conn)
+}
 
-	msgs, err := ch.Consume(
-		client.config.NotificationQueue,
-		"",
-		false, // we manually ack/nack
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatalf("Failed to register a consumer: %v", err)
+func (client *RabbitMQClient) declareTopology(conn *amqp091.Connection) error {
+	return rabbitmq.DeclareTopology(conn, client.config.DLXExchange, client.config.DLXQueue, client.config.NotificationQueue)
+}
+
+// Close stops consuming and closes the connection.
+func (client *RabbitMQClient) Close() error {
+	return client.connections.Close()
+}
+
+// CheckConnection reports whether the AMQP connection is open.
+func (client *RabbitMQClient) CheckConnection(ctx context.Context) error {
+	state, reason := client.connections.State()
+	switch state {
+	case rabbitmq.StateConnected:
+		return nil
+	case rabbitmq.StateBlocked:
+		return fmt.Errorf("RabbitMQ connection is blocked: %s", reason)
+	default:
+		return fmt.Errorf("RabbitMQ connection is %s", state)
 	}
+}
 
+func (client *RabbitMQClient) StartConsuming(handler func(amqp091.Delivery) error) {
 	maxWorkersStr := os.Getenv("MAX_WORKERS")
 	var maxWorkers int
+	var err error
 	if maxWorkersStr != "" {
 		maxWorkers, err = strconv.Atoi(maxWorkersStr)
 		if err != nil {
@@ -82,16 +112,105 @@ func (client *RabbitMQClient) StartConsuming(handler func(amqp091.Delivery) erro
 	} else {
 		maxWorkers = runtime.NumCPU() * 2
 	}
+
 	sem := make(chan struct{}, maxWorkers)
+	metrics.SetMaxWorkers(maxWorkers)
 
-	for d := range msgs {
+	// Consumers die with their connection, so register them again whenever
+	// the connection manager has a new one.
+	for {
+		conn, err := client.connections.Connection()
+		if errors.Is(err, rabbitmq.ErrConnectionClosed) {
+			return
+		}
+		if err == nil {
+			err = client.consume(conn, maxWorkers, sem, handler)
+		}
+		if errors.Is(err, rabbitmq.ErrConnectionClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Consumers are not running: %v", err)
+		}
+		time.Sleep(consumerRetryDelay)
+	}
+}
+
+// consume registers one consumer per priority lane on conn and dispatches
+// deliveries until any lane's channel closes.
+func (client *RabbitMQClient) consume(conn *amqp091.Connection, maxWorkers int, sem chan struct{}, handler func(amqp091.Delivery) error) error {
+	lanes := make([]*lane, len(common.Priorities))
+	channels := make([]*amqp091.Channel, 0, len(common.Priorities))
+	defer func() {
+		for _, ch := range channels {
+			ch.Close()
+		}
+	}()
+
+	for i, priority := range common.Priorities {
+		ch, err := conn.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open a channel: %v", err)
+		}
+		channels = append(channels, ch)
+
+		// Bound the prefetch so a flood in one lane stays on the broker
+		// instead of piling up in front of more urgent lanes.
+		if err := ch.Qos(maxWorkers, 0, false); err != nil {
+			return fmt.Errorf("failed to set QoS: %v", err)
+		}
+
+		msgs, err := ch.Consume(
+			common.QueueNameForPriority(client.config.NotificationQueue, priority),
+			"",
+			false, // we manually ack/nack
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register a consumer: %v", err)
+		}
+		lanes[i] = &lane{priority: priority, weight: laneWeights[priority], msgs: msgs}
+	}
+
+	// A channel error on one lane closes only that lane; close the others as
+	// well so every consumer is registered again together.
+	var closeAll sync.Once
+	for _, ch := range channels {
+		closed := ch.NotifyClose(make(chan *amqp091.Error, 1))
+		go func() {
+			<-closed
+			closeAll.Do(func() {
+				for _, ch := range channels {
+					ch.Close()
+				}
+			})
+		}()
+	}
+
+	log.Printf("Consuming from %d priority lanes", len(lanes))
+	dispatcher := newLaneDispatcher(lanes)
+	for {
 		sem <- struct{}{}
+		d, ok := dispatcher.next()
+		if !ok {
+			<-sem
+			log.Printf("Delivery channels closed")
+			return nil
+		}
+		metrics.IncInFlight()
 		go func(d amqp091.Delivery) {
-			defer func() { <-sem }()
+			defer func() {
+				metrics.DecInFlight()
+				<-sem
+			}()
 			if err := handler(d); err != nil {
 				client.handleProcessingError(err, d)
 			} else {
 				d.Ack(false)
+				metrics.CountMessage(metrics.OutcomeAck)
 			}
 		}(d)
 	}
@@ -101,32 +220,117 @@ func (client *RabbitMQClient) handleProcessingError(err error, d amqp091.Deliver
 	switch e := err.(type) {
 	case *models.RetryError:
 		updatedMessageBytes, _ := json.Marshal(e.UpdatedMessage)
-		if requeueErr := client.requeueMessage(updatedMessageBytes); requeueErr != nil {
+		if requeueErr := client.publish(client.laneOf(d), updatedMessageBytes); requeueErr != nil {
+			// The retry copy was not confirmed, so hand the original back to
+			// the broker instead of acking it and losing the notification.
 			log.Printf("Failed to requeue message: %v", requeueErr)
+			d.Nack(false, true)
+			metrics.CountMessage(metrics.OutcomeRequeue)
+			return
 		}
 		d.Ack(false)
-	case *models.DeserializingMsgError, *models.ProcessingTypeError, *models.MaxRetryError:
+		metrics.CountMessage(metrics.OutcomeRetry)
+	case *models.DeserializingMsgError, *models.ProcessingTypeError, *models.MaxRetryError, *models.TemplateError, *models.AttachmentError:
 		d.Nack(false, false) // Send to DLQ
+		metrics.CountMessage(metrics.OutcomeDLQ)
 	default:
 		d.Nack(false, true) // Requeue for temporary issues
+		metrics.CountMessage(metrics.OutcomeRequeue)
+	}
+}
+
+// PublishNotification queues a new notification in its priority lane and
+// waits for the broker to confirm it.
+func (client *RabbitMQClient) PublishNotification(notificationMsg common.NotificationMessage) error {
+	body, err := json.Marshal(notificationMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	queueName := common.QueueNameForPriority(client.config.NotificationQueue, notificationMsg.Notification.Priority)
+	return client.publish(queueName, body)
+}
+
+// PublishNotificationAfter queues a notification that reaches its priority
+// lane once delay has passed. It waits in a delay queue whose messages expire
+// back into the lane. Delays are rounded up to the minute so few delay queues
+// exist at once, and a delay queue deletes itself once it has been unused for
+// longer than its delay.
+func (client *RabbitMQClient) PublishNotificationAfter(notificationMsg common.NotificationMessage, delay time.Duration) error {
+	body, err := json.Marshal(notificationMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	lane := common.QueueNameForPriority(client.config.NotificationQueue, notificationMsg.Notification.Priority)
+	delayQueue, err := client.declareDelayQueue(lane, delay)
+	if err != nil {
+		return err
 	}
+	return client.publish(delayQueue, body)
 }
 
-func (client *RabbitMQClient) requeueMessage(updatedMessage []byte) error {
-	ch, err := client.Connection.Channel()
+func (client *RabbitMQClient) declareDelayQueue(lane string, delay time.Duration) (string, error) {
+	minutes := int64((delay + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	ttl := minutes * int64(time.Minute/time.Millisecond)
+	name := fmt.Sprintf("%s.delay.%d", lane, ttl)
+
+	conn, err := client.connections.Connection()
+	if err != nil {
+		return "", err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return "", fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclare(
+		name,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		amqp091.Table{
+			"x-message-ttl":             ttl,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": lane,
+			"x-expires":                 ttl + delayQueueExpiryGrace.Milliseconds(),
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to declare delay queue %s: %v", name, err)
+	}
+	return name, nil
+}
+
+// publish sends a message and waits for the broker to confirm it, so a
+// retried original is only acked once its copy is safe.
+func (client *RabbitMQClient) publish(queueName string, updatedMessage []byte) error {
+	conn, err := client.connections.Connection()
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
 	if err != nil {
 		return fmt.Errorf("failed to open a channel: %v", err)
 	}
 	defer ch.Close()
 
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to put channel in confirm mode: %v", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp091.Return, 1))
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	err = ch.PublishWithContext(
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
 		ctx,
-		"",                              // exchange
-		client.config.NotificationQueue, // routing key (queue name)
-		false,                           // mandatory
-		false,                           // immediate
+		"",        // exchange
+		queueName, // routing key (queue name)
+		true,      // mandatory
+		false,     // immediate
 		amqp091.Publishing{
 			DeliveryMode: amqp091.Persistent,
 			Timestamp:    time.Now(),
@@ -134,10 +338,32 @@ func (client *RabbitMQClient) requeueMessage(updatedMessage []byte) error {
 			Body:         updatedMessage,
 		},
 	)
-
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %v", err)
 	}
 
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("timed out waiting for broker confirmation: %v", err)
+	}
+	if !acked {
+		return fmt.Errorf("message was nacked by the broker")
+	}
+	// A return for an unroutable message arrives before its ack.
+	select {
+	case ret := <-returns:
+		return fmt.Errorf("message to queue %s was returned by the broker: %d %s", queueName, ret.ReplyCode, ret.ReplyText)
+	default:
+	}
+
 	return nil
 }
+
+// laneOf returns the queue a delivery was consumed from, so retries stay in
+// the same priority lane.
+func (client *RabbitMQClient) laneOf(d amqp091.Delivery) string {
+	if d.Exchange == "" && d.RoutingKey != "" {
+		return d.RoutingKey
+	}
+	return client.config.NotificationQueue
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcknowledger records how a delivery was settled.
+type fakeAcknowledger struct {
+	acked    bool
+	nacked   bool
+	requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked = true
+	a.requeued = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestHandleProcessingError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		deadLetter bool
+	}{
+		{"undecodable message", models.NewDeserializingMsgError("bad json"), true},
+		{"unknown type", models.NewProcessingTypeError("push"), true},
+		{"out of retries", models.NewMaxRetryError("gave up"), true},
+		{"broken template", models.NewTemplateError("template welcome version 2 not found"), true},
+		{"missing attachment", models.NewAttachmentError("attachment not found, it may have expired"), true},
+		{"temporary failure", errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acknowledger := &fakeAcknowledger{}
+			client := &RabbitMQClient{}
+			client.handleProcessingError(tt.err, amqp091.Delivery{Acknowledger: acknowledger})
+			if acknowledger.acked || !acknowledger.nacked {
+				t.Fatalf("delivery acked = %v, nacked = %v, want a nack", acknowledger.acked, acknowledger.nacked)
+			}
+			if acknowledger.requeued == tt.deadLetter {
+				t.Errorf("requeued = %v, want dead-lettered = %v", acknowledger.requeued, tt.deadLetter)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
+)
+
+const (
+	signatureHeader = "X-Webhook-Signature"
+	timestampHeader = "X-Webhook-Timestamp"
+	idHeader        = "X-Webhook-Id"
+)
+
+// Dispatcher delivers stored webhook events in the background, so a slow or
+// failing receiver never holds up notification processing.
+type Dispatcher struct {
+	Repo        db.WebhookRepository
+	Client      *http.Client
+	Interval    time.Duration
+	BatchSize   int
+	Concurrency int
+	MaxAttempts int
+	Retention   time.Duration
+	// Lease must cover delivering a whole batch, or another worker may pick
+	// up the same deliveries.
+	Lease time.Duration
+}
+
+func NewDispatcher(repo db.WebhookRepository, interval time.Duration, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		Repo:        repo,
+		Client:      newClient(10 * time.Second),
+		Interval:    interval,
+		BatchSize:   50,
+		Concurrency: 8,
+		MaxAttempts: maxAttempts,
+		Retention:   7 * 24 * time.Hour,
+		Lease:       2 * time.Minute,
+	}
+}
+
+// newClient returns the client webhooks are sent with. It only connects to
+// public addresses, checked after DNS resolution so a host name cannot point
+// it at the service's own network, and it does not follow redirects.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: refusePrivateAddress,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errors.New("webhook receivers must not redirect")
+		},
+	}
+}
+
+// refusePrivateAddress is a net.Dialer Control function. It runs for every
+// resolved address before connecting.
+func refusePrivateAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !common.IsPublicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// Run delivers due events and purges finished ones until the context is
+// cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		case <-cleanup.C:
+			d.deleteFinished(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	for {
+		deliveries, err := d.Repo.ClaimWebhooks(ctx, d.BatchSize, d.Lease)
+		if err != nil {
+			log.Printf("Error claiming webhook deliveries: %v", err)
+			return
+		}
+
+		sem := make(chan struct{}, d.Concurrency)
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(delivery db.WebhookDelivery) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				d.deliver(ctx, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < d.BatchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery db.WebhookDelivery) {
+	err := d.post(ctx, delivery)
+	if err == nil {
+		metrics.CountWebhook(metrics.WebhookDelivered)
+		if err := d.Repo.MarkWebhookDelivered(ctx, delivery.ID); err != nil {
+			log.Printf("Error marking webhook %s delivered: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	gaveUp, markErr := d.Repo.MarkWebhookFailed(ctx, delivery.ID, err.Error(), d.MaxAttempts)
+	if markErr != nil {
+		log.Printf("Error marking webhook %s failed: %v", delivery.ID, markErr)
+		return
+	}
+	if gaveUp {
+		metrics.CountWebhook(metrics.WebhookFailed)
+		log.Printf("Giving up on webhook %s for notification %s after %d attempts: %v", delivery.ID, delivery.NotificationID, delivery.Attempts+1, err)
+		return
+	}
+	metrics.CountWebhook(metrics.WebhookRetry)
+	log.Printf("Webhook %s for notification %s failed, will retry: %v", delivery.ID, delivery.NotificationID, err)
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery db.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %v", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idHeader, delivery.ID)
+	req.Header.Set(timestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(signatureHeader, "sha256="+Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) deleteFinished(ctx context.Context) {
+	deleted, err := d.Repo.DeleteFinishedWebhooks(ctx, time.Now().Add(-d.Retention))
+	if err != nil {
+		log.Printf("Error deleting finished webhook deliveries: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d finished webhook deliveries", deleted)
+	}
+}
@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+)
+
+func TestRefusePrivateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.0.0.5:80", false},
+		{"172.16.3.4:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"0.0.0.0:80", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := refusePrivateAddress("tcp", tt.address, nil)
+			if (err == nil) != tt.allowed {
+				t.Errorf("refusePrivateAddress(%q) = %v, want allowed %v", tt.address, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestPostRefusesLoopbackReceiver(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	d := &Dispatcher{Client: newClient(time.Second)}
+	err := d.post(context.Background(), db.WebhookDelivery{ID: "1", URL: server.URL, Payload: []byte("{}")})
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("post() = %v, want the loopback address refused", err)
+	}
+	if called {
+		t.Error("the loopback receiver was called")
+	}
+}
+
+func TestClientRefusesRedirects(t *testing.T) {
+	client := newClient(time.Second)
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hook", nil)
+	if err := client.CheckRedirect(req, []*http.Request{req}); err == nil {
+		t.Error("CheckRedirect allowed a redirect")
+	}
+}
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+)
+
+const (
+	EventDelivered = "notification.delivered"
+	EventRetrying  = "notification.retrying"
+	EventFailed    = "notification.failed"
+)
+
+// Event is the JSON body POSTed to a webhook receiver.
+type Event struct {
+	ID             string                    `json:"id"`
+	Type           string                    `json:"type"`
+	NotificationID string                    `json:"notificationId"`
+	Status         common.NotificationStatus `json:"status"`
+	RetryCount     int                       `json:"retryCount"`
+	Error          string                    `json:"error,omitempty"`
+	OccurredAt     time.Time                 `json:"occurredAt"`
+}
+
+// EventTypeFor returns the event sent for a status change, or false when the
+// status is not reported to receivers.
+func EventTypeFor(status common.NotificationStatus) (string, bool) {
+	switch status {
+	case common.DeliveredStatus:
+		return EventDelivered, true
+	case common.RetryingStatus:
+		return EventRetrying, true
+	case common.FailedStatus, common.DeadLetteredStatus:
+		return EventFailed, true
+	default:
+		return "", false
+	}
+}
+
+// Enqueue stores the event for a status change of the notification, for the
+// dispatcher to send to the submitting API key's webhook. Statuses that are
+// not reported and notifications without an API key are ignored.
+func Enqueue(ctx context.Context, repo db.WebhookRepository, notification common.Notification, status common.NotificationStatus, retryCount int, lastError string) error {
+	eventType, ok := EventTypeFor(status)
+	if !ok || notification.APIKeyID == "" {
+		return nil
+	}
+
+	event := Event{
+		ID:             uuid.NewString(),
+		Type:           eventType,
+		NotificationID: notification.ID,
+		Status:         status,
+		RetryCount:     retryCount,
+		Error:          lastError,
+		OccurredAt:     time.Now().UTC(),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+	return repo.EnqueueWebhook(ctx, event.ID, notification.APIKeyID, notification.ID, notification.CallbackURL, payload)
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" under secret.
+// Covering the timestamp lets receivers reject replayed requests.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
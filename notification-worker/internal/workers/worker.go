@@ -1,22 +1,31 @@
 package workers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/pdragnev/notification-system/common"
 	"github.com/pdragnev/notification-system/notification-worker/internal/db"
+	"github.com/pdragnev/notification-system/notification-worker/internal/metrics"
 	"github.com/pdragnev/notification-system/notification-worker/internal/models"
 	"github.com/pdragnev/notification-system/notification-worker/internal/notifications"
 	"github.com/pdragnev/notification-system/notification-worker/internal/queue"
+	"github.com/pdragnev/notification-system/notification-worker/internal/webhooks"
 	"github.com/rabbitmq/amqp091-go"
 )
 
 var maxRetryCount int
 
+// dedupeClaimLease bounds how long a dedupe key stays claimed by a worker
+// that died while processing its message.
+const dedupeClaimLease = 5 * time.Minute
+
 func init() {
 	var err error
 	maxRetryCount, err = strconv.Atoi(os.Getenv("MAX_RETRY_COUNT"))
@@ -26,14 +35,36 @@ func init() {
 }
 
 type NotificationWorker struct {
-	QueueClient *queue.RabbitMQClient
-	UserRepo    db.UserRepository
+	QueueClient    *queue.RabbitMQClient
+	UserRepo       db.UserRepository
+	StatusRepo     db.NotificationStatusRepository
+	TemplateRepo   db.TemplateRepository
+	WebhookRepo    db.WebhookRepository
+	PreferenceRepo db.PreferenceRepository
+	DigestRepo     db.DigestRepository
+	// DigestWindows is how long notifications with a digest key are
+	// buffered, per category. Categories without a window are sent at once.
+	DigestWindows map[common.Category]time.Duration
+	DedupeRepo    db.DedupeRepository
+	// DedupeWindow is how long a delivered message's dedupe key keeps
+	// duplicates out. Zero turns deduplication off.
+	DedupeWindow   time.Duration
+	AttachmentRepo db.AttachmentRepository
 }
 
-func NewNotificationWorker(queueClient *queue.RabbitMQClient, repo db.UserRepository) *NotificationWorker {
+func NewNotificationWorker(queueClient *queue.RabbitMQClient, repo db.UserRepository, statusRepo db.NotificationStatusRepository, templateRepo db.TemplateRepository, webhookRepo db.WebhookRepository, preferenceRepo db.PreferenceRepository, digestRepo db.DigestRepository, digestWindows map[common.Category]time.Duration, dedupeRepo db.DedupeRepository, dedupeWindow time.Duration, attachmentRepo db.AttachmentRepository) *NotificationWorker {
 	return &NotificationWorker{
-		QueueClient: queueClient,
-		UserRepo:    repo,
+		QueueClient:    queueClient,
+		UserRepo:       repo,
+		StatusRepo:     statusRepo,
+		TemplateRepo:   templateRepo,
+		WebhookRepo:    webhookRepo,
+		PreferenceRepo: preferenceRepo,
+		DigestRepo:     digestRepo,
+		DigestWindows:  digestWindows,
+		DedupeRepo:     dedupeRepo,
+		DedupeWindow:   dedupeWindow,
+		AttachmentRepo: attachmentRepo,
 	}
 }
 
@@ -50,29 +81,234 @@ func (worker *NotificationWorker) ProcessMessage(message []byte) error {
 	if notificationMsg.RetryCount >= maxRetryCount {
 		strErr := fmt.Sprintf("Max retries exceeded for message: %v", notificationMsg)
 		log.Print(strErr)
+		worker.recordStatus(notificationMsg, common.DeadLetteredStatus, strErr)
 		return models.NewMaxRetryError(strErr)
 	}
 
 	notification := notificationMsg.Notification
 
-	processor, err := notifications.GetProcessorForType(string(notification.Type), worker.UserRepo)
+	base := notifications.BaseProcessor{
+		UserRepo:       worker.UserRepo,
+		TemplateRepo:   worker.TemplateRepo,
+		StatusRepo:     worker.StatusRepo,
+		PreferenceRepo: worker.PreferenceRepo,
+		AttachmentRepo: worker.AttachmentRepo,
+		Publisher:      worker.QueueClient,
+	}
+	processor, err := notifications.GetProcessorForType(string(notification.Type), base)
 	if err != nil {
 		strErr := fmt.Sprintf("Error getting processor for type %s: %v", notification.Type, err)
 		log.Print(strErr)
+		worker.recordStatus(notificationMsg, common.FailedStatus, strErr)
 		return models.NewProcessingTypeError(strErr)
 	}
 
-	// Process the notification
-	err = processor.Process(notificationMsg)
+	claimed, proceed := worker.claimDedupeKey(notificationMsg)
+	if !proceed {
+		return nil
+	}
+	handled := false
+	if claimed {
+		defer func() { worker.finishDedupeKey(notificationMsg, handled) }()
+	}
+
+	worker.recordStatus(notificationMsg, common.ProcessingStatus, "")
+
+	// Process the notification, or buffer it until its digest is sent
+	digested := false
+	if window := worker.digestWindow(notification); window > 0 {
+		err = worker.addToDigest(base, notification, window)
+		digested = true
+	} else {
+		start := time.Now()
+		err = processor.Process(notificationMsg)
+		metrics.ObserveProcess(string(notification.Type), start)
+	}
+	var noRecipientsErr *models.NoRecipientsError
+	if errors.As(err, &noRecipientsErr) {
+		// Everyone opted out or could not be reached; retrying cannot help.
+		log.Printf("Skipping notification: %v", err)
+		worker.recordStatus(notificationMsg, common.SkippedStatus, err.Error())
+		handled = true
+		return nil
+	}
+	var templateErr *models.TemplateError
+	if errors.As(err, &templateErr) {
+		// A broken template fails the same way on every attempt.
+		log.Printf("Error rendering notification: %v", err)
+		worker.recordStatus(notificationMsg, common.FailedStatus, err.Error())
+		return err
+	}
+	var attachmentErr *models.AttachmentError
+	if errors.As(err, &attachmentErr) {
+		log.Printf("Error attaching files to notification: %v", err)
+		worker.recordStatus(notificationMsg, common.FailedStatus, err.Error())
+		return err
+	}
 	if err != nil {
 		log.Printf("Error processing notification: %v", err)
 		notificationMsg.RetryCount++
+		worker.recordStatus(notificationMsg, common.RetryingStatus, err.Error())
 		return models.NewRetryError("Retry due to temporary condition", notificationMsg)
 	}
 
+	// AddToDigest marked the notification digested along with its items;
+	// writing it again could undo a digest that was already settled.
+	if !digested {
+		worker.recordStatus(notificationMsg, common.DeliveredStatus, "")
+	}
+	handled = true
 	return nil
 }
 
+// claimDedupeKey reserves the message's dedupe key. It reports whether the
+// key was claimed and whether to process the message, which is false for a
+// duplicate. When the store cannot be reached the message is processed
+// unclaimed: a possible duplicate is better than a lost notification.
+func (worker *NotificationWorker) claimDedupeKey(notificationMsg common.NotificationMessage) (bool, bool) {
+	notification := notificationMsg.Notification
+	if worker.DedupeWindow <= 0 || notification.ID == "" {
+		return false, true
+	}
+
+	claimed, holder, err := worker.DedupeRepo.Claim(context.Background(), dedupeKeyOf(notificationMsg), notification.ID, dedupeClaimLease)
+	if err != nil {
+		log.Printf("Failed to check notification %s for duplicates: %v", notification.ID, err)
+		return false, true
+	}
+	if claimed {
+		return true, true
+	}
+
+	log.Printf("Dropping notification %s as a duplicate of notification %s", notification.ID, holder)
+	metrics.CountDuplicate()
+	// A redelivery of a handled message keeps the status it already has.
+	if holder != notification.ID {
+		worker.recordStatus(notificationMsg, common.SkippedStatus, "duplicate of notification "+holder)
+	}
+	return false, false
+}
+
+// finishDedupeKey keeps a handled message's key for the dedupe window and
+// frees the key of a message that failed, so its retry can claim it again.
+func (worker *NotificationWorker) finishDedupeKey(notificationMsg common.NotificationMessage, handled bool) {
+	notification := notificationMsg.Notification
+	key := dedupeKeyOf(notificationMsg)
+	var err error
+	if handled {
+		err = worker.DedupeRepo.Complete(context.Background(), key, notification.ID, worker.DedupeWindow)
+	} else {
+		err = worker.DedupeRepo.Release(context.Background(), key, notification.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to update dedupe key of notification %s: %v", notification.ID, err)
+	}
+}
+
+// dedupeKeyOf falls back to the content hash for messages queued without a
+// key, such as those published before keys existed.
+func dedupeKeyOf(notificationMsg common.NotificationMessage) string {
+	if notificationMsg.DedupeKey != "" {
+		return notificationMsg.DedupeKey
+	}
+	return common.DedupeKeyFor(notificationMsg.Notification)
+}
+
+// PurgeDedupeKeys deletes expired dedupe keys every interval until the
+// context is cancelled.
+func (worker *NotificationWorker) PurgeDedupeKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := worker.DedupeRepo.DeleteExpired(ctx)
+			if err != nil {
+				log.Printf("Error deleting expired dedupe keys: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d expired dedupe keys", deleted)
+			}
+		}
+	}
+}
+
+func (worker *NotificationWorker) digestWindow(notification common.Notification) time.Duration {
+	if notification.DigestKey == "" {
+		return 0
+	}
+	// A digest only carries text, so files go out with their own message.
+	if notification.Email != nil && len(notification.Email.Attachments) > 0 {
+		return 0
+	}
+	return worker.DigestWindows[notification.Category.OrDefault()]
+}
+
+// addToDigest renders the notification to plain text in each recipient's
+// locale and buffers it for them. The flusher sends it with the rest of the
+// digest.
+func (worker *NotificationWorker) addToDigest(base notifications.BaseProcessor, notification common.Notification, window time.Duration) error {
+	groups, err := base.SplitByLocale(context.Background(), notification)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		content, err := base.RenderContent(context.Background(), group.Notification, group.Locale)
+		if err != nil {
+			return err
+		}
+		text := common.RenderedTemplate{Subject: content.Subject, Body: content.Text}
+		if err := worker.DigestRepo.AddToDigest(context.Background(), group.Notification, text, window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordStatus stores a lifecycle transition so the API can report it.
+// Failures are logged only, a missing status must never block delivery.
+func (worker *NotificationWorker) recordStatus(notificationMsg common.NotificationMessage, status common.NotificationStatus, lastError string) {
+	if notificationMsg.Notification.ID == "" {
+		return
+	}
+	err := worker.StatusRepo.UpdateStatus(context.Background(), notificationMsg.Notification, status, notificationMsg.RetryCount, lastError)
+	if err != nil {
+		log.Printf("Failed to record status %s for notification %s: %v", status, notificationMsg.Notification.ID, err)
+	}
+	worker.enqueueWebhook(notificationMsg, status, lastError)
+	if notificationMsg.Notification.DigestID != "" && status.IsFinal() {
+		worker.settleDigest(notificationMsg.Notification.DigestID, status, lastError)
+	}
+}
+
+// settleDigest passes the final status of a digest notification on to the
+// notifications buffered in the digest, and reports their new status.
+func (worker *NotificationWorker) settleDigest(digestID string, status common.NotificationStatus, lastError string) {
+	settled, err := worker.DigestRepo.Settle(context.Background(), digestID, status, lastError)
+	if err != nil {
+		log.Printf("Failed to settle digest %s: %v", digestID, err)
+		return
+	}
+	for _, notification := range settled {
+		err := webhooks.Enqueue(context.Background(), worker.WebhookRepo, notification.Notification, notification.Status, 0, notification.LastError)
+		if err != nil {
+			log.Printf("Failed to enqueue webhook event for notification %s: %v", notification.Notification.ID, err)
+		}
+	}
+}
+
+// enqueueWebhook stores a status event for the submitting API key's webhook.
+// The dispatcher sends it later, so a slow receiver never delays processing.
+func (worker *NotificationWorker) enqueueWebhook(notificationMsg common.NotificationMessage, status common.NotificationStatus, lastError string) {
+	notification := notificationMsg.Notification
+	err := webhooks.Enqueue(context.Background(), worker.WebhookRepo, notification, status, notificationMsg.RetryCount, lastError)
+	if err != nil {
+		log.Printf("Failed to enqueue webhook event for notification %s: %v", notification.ID, err)
+	}
+}
+
 func (worker *NotificationWorker) Start() {
 	handler := func(d amqp091.Delivery) error {
 		return worker.ProcessMessage(d.Body)
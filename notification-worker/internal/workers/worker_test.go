@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pdragnev/notification-system/common"
+	"github.com/pdragnev/notification-system/notification-worker/internal/models"
+)
+
+type dedupeEntry struct {
+	notificationID string
+	completed      bool
+}
+
+type fakeDedupeRepo struct {
+	keys map[string]dedupeEntry
+}
+
+func (r *fakeDedupeRepo) Claim(ctx context.Context, key string, notificationID string, lease time.Duration) (bool, string, error) {
+	if entry, ok := r.keys[key]; ok {
+		return false, entry.notificationID, nil
+	}
+	r.keys[key] = dedupeEntry{notificationID: notificationID}
+	return true, "", nil
+}
+
+func (r *fakeDedupeRepo) Complete(ctx context.Context, key string, notificationID string, window time.Duration) error {
+	r.keys[key] = dedupeEntry{notificationID: notificationID, completed: true}
+	return nil
+}
+
+func (r *fakeDedupeRepo) Release(ctx context.Context, key string, notificationID string) error {
+	if entry, ok := r.keys[key]; ok && entry.notificationID == notificationID && !entry.completed {
+		delete(r.keys, key)
+	}
+	return nil
+}
+
+func (r *fakeDedupeRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+type statusUpdate struct {
+	id        string
+	status    common.NotificationStatus
+	lastError string
+}
+
+type fakeStatusRepo struct {
+	updates []statusUpdate
+}
+
+func (r *fakeStatusRepo) UpdateStatus(ctx context.Context, notification common.Notification, status common.NotificationStatus, retryCount int, lastError string) error {
+	r.updates = append(r.updates, statusUpdate{notification.ID, status, lastError})
+	return nil
+}
+
+func (r *fakeStatusRepo) CreateNotification(ctx context.Context, notification common.Notification, status common.NotificationStatus) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeStatusRepo) RecordSkippedRecipients(ctx context.Context, notification common.Notification, skipped []models.SkippedRecipient) error {
+	return nil
+}
+
+func TestClaimDedupeKey(t *testing.T) {
+	original := common.Notification{
+		ID:        "5b0f4c1e-8f2a-4a8e-9c36-0d6a1c2b7e11",
+		Type:      common.EmailNotificationType,
+		To:        []common.Recipient{common.UserRecipient("563c1e2a-0b3e-4a55-9b0e-0d6a1c2b7e11")},
+		From:      "noreply@example.com",
+		Subject:   "Hello",
+		Content:   "Hi there",
+		DedupeKey: "order-1042",
+	}
+	copyOf := func(id string) common.NotificationMessage {
+		copied := original
+		copied.ID = id
+		return common.NewCopyMessage(copied)
+	}
+	duplicate := original
+	duplicate.ID = "9d1c7a0e-3b6f-4c1e-8a2d-5e4f3a2b1c0d"
+
+	tests := []struct {
+		name        string
+		message     common.NotificationMessage
+		wantProcess bool
+		wantSkipped bool
+	}{
+		{"rerouted copy", copyOf("rerouted"), true, false},
+		{"deferred copy", copyOf("deferred"), true, false},
+		{"digest of one item", copyOf("digest"), true, false},
+		{"redelivered original", common.NewNotificationMessage(original), false, false},
+		{"redelivered copy", copyOf("rerouted"), false, false},
+		{"caller duplicate", common.NewNotificationMessage(duplicate), false, true},
+	}
+
+	statusRepo := &fakeStatusRepo{}
+	worker := &NotificationWorker{
+		StatusRepo:   statusRepo,
+		DedupeRepo:   &fakeDedupeRepo{keys: make(map[string]dedupeEntry)},
+		DedupeWindow: 10 * time.Minute,
+	}
+	originalMsg := common.NewNotificationMessage(original)
+	if claimed, process := worker.claimDedupeKey(originalMsg); !claimed || !process {
+		t.Fatalf("original: claimed %v, process %v", claimed, process)
+	}
+	worker.finishDedupeKey(originalMsg, true)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusRepo.updates = nil
+			claimed, process := worker.claimDedupeKey(tt.message)
+			if process != tt.wantProcess || claimed != tt.wantProcess {
+				t.Errorf("claimed %v, process %v, want %v", claimed, process, tt.wantProcess)
+			}
+			if claimed {
+				worker.finishDedupeKey(tt.message, true)
+			}
+			skipped := len(statusRepo.updates) == 1 && statusRepo.updates[0].status == common.SkippedStatus
+			if skipped != tt.wantSkipped {
+				t.Errorf("status updates %v, want skipped %v", statusRepo.updates, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    retry_count INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    api_key_id VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS api_key_id VARCHAR(36) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(300) PRIMARY KEY,
+    request_hash CHAR(64) NOT NULL,
+    reservation_id UUID,
+    status_code INT,
+    content_type VARCHAR(255),
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- A pending key expires when the lease of the request holding it runs out.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS reservation_id UUID;
+
+CREATE TABLE IF NOT EXISTS notification_skipped_recipients (
+    notification_id UUID NOT NULL REFERENCES notifications (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    rerouted_to UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (notification_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_notifications (
+    id UUID PRIMARY KEY REFERENCES notifications (id) ON DELETE CASCADE,
+    send_at TIMESTAMPTZ NOT NULL,
+    queue_name VARCHAR(255) NOT NULL,
+    message JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_notifications_send_at_idx ON scheduled_notifications (send_at);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    notification_id UUID NOT NULL REFERENCES notifications (id) ON DELETE CASCADE,
+    queue_name VARCHAR(255) NOT NULL,
+    message JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    failed_at TIMESTAMPTZ
+);
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (created_at, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_sent_at_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS outbox_failed_at_idx ON outbox (failed_at) WHERE failed_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS templates (
+    name VARCHAR(100) NOT NULL,
+    version INT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    -- Per-locale variants: {"bg": {"subject": "...", "body": "..."}}
+    localized JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (name, version)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    api_key_id UUID NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    notification_type VARCHAR(20) NOT NULL DEFAULT '*',
+    rate_per_second DOUBLE PRECISION NOT NULL,
+    burst INT NOT NULL,
+    PRIMARY KEY (api_key_id, notification_type)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    full_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS full_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_full_at_idx ON rate_limit_buckets (full_at);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    api_key_id UUID PRIMARY KEY REFERENCES api_keys (id) ON DELETE CASCADE,
+    url TEXT NOT NULL DEFAULT '',
+    secret VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES webhook_subscriptions (api_key_id) ON DELETE CASCADE,
+    notification_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ,
+    failed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at)
+    WHERE delivered_at IS NULL AND failed_at IS NULL;
+
+-- Digests collect notifications that share a digest key per recipient until
+-- closes_at, then the worker sends them as one notification.
+CREATE TABLE IF NOT EXISTS digests (
+    id UUID PRIMARY KEY,
+    api_key_id VARCHAR(36) NOT NULL DEFAULT '',
+    digest_key VARCHAR(200) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    category VARCHAR(50) NOT NULL,
+    recipient_key VARCHAR(300) NOT NULL,
+    recipient JSONB NOT NULL,
+    from_address VARCHAR(255) NOT NULL,
+    closes_at TIMESTAMPTZ NOT NULL,
+    claimed_at TIMESTAMPTZ,
+    flushed_at TIMESTAMPTZ,
+    outcome VARCHAR(20),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE digests ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+-- outcome is the final status of the notification that sent the digest.
+ALTER TABLE digests ADD COLUMN IF NOT EXISTS outcome VARCHAR(20);
+ALTER TABLE digests ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+-- At most one open digest per recipient and key. A digest stops taking items
+-- once a worker claimed it for flushing.
+DROP INDEX IF EXISTS digests_open_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS digests_unclaimed_idx ON digests (api_key_id, digest_key, type, recipient_key)
+    WHERE flushed_at IS NULL AND claimed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS digests_due_idx ON digests (closes_at) WHERE flushed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS digest_items (
+    digest_id UUID NOT NULL REFERENCES digests (id) ON DELETE CASCADE,
+    notification_id UUID NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    callback_url TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (digest_id, notification_id)
+);
+
+ALTER TABLE digest_items ADD COLUMN IF NOT EXISTS callback_url TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS digest_items_notification_idx ON digest_items (notification_id);
+
+-- Dedupe keys the worker has claimed or delivered. A message whose key has a
+-- row that has not expired is a duplicate.
+CREATE TABLE IF NOT EXISTS notification_dedupe (
+    dedupe_key VARCHAR(100) PRIMARY KEY,
+    notification_id UUID NOT NULL,
+    state VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS notification_dedupe_expires_idx ON notification_dedupe (expires_at);
+
+-- Email attachments, uploaded or taken from notification requests. Queued
+-- messages reference them by ID. The ID is derived from the API key, file
+-- name and content, so the same file is stored once.
+CREATE TABLE IF NOT EXISTS attachments (
+    id UUID PRIMARY KEY,
+    api_key_id VARCHAR(36) NOT NULL DEFAULT '',
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size INT NOT NULL,
+    content BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_expires_idx ON attachments (expires_at);
@@ -4,10 +4,50 @@ CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     email VARCHAR(255) NOT NULL UNIQUE,
     phone_number VARCHAR(20),
-    opted_in BOOLEAN DEFAULT TRUE
+    opted_in BOOLEAN DEFAULT TRUE,
+    -- IANA name; quiet hours are read in this zone.
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    -- Non-critical notifications are held back between start and end. An end
+    -- before the start means the window runs past midnight.
+    quiet_hours_start TIME,
+    quiet_hours_end TIME,
+    -- BCP 47 tag such as bg-BG; picks the localized content to send.
+    locale VARCHAR(35)
 );
 
-INSERT INTO users (id, email, phone_number, opted_in) VALUES
-('80fc203f-3856-43a5-b2d3-b604a640ec54', 'petar@vasilkotsev.com', '+359892091234', TRUE),
-('563cfe60-6ed7-49ac-ba33-f05758831980', 'testing@vasilkotsev.com', '+359890123456', TRUE);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start TIME;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end TIME;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
 
+INSERT INTO users (id, email, phone_number, opted_in, time_zone, locale) VALUES
+('80fc203f-3856-43a5-b2d3-b604a640ec54', 'petar@vasilkotsev.com', '+359892091234', TRUE, 'Europe/Sofia', 'bg-BG'),
+('563cfe60-6ed7-49ac-ba33-f05758831980', 'testing@vasilkotsev.com', '+359890123456', TRUE, 'Europe/Sofia', 'en')
+ON CONFLICT (id) DO NOTHING;
+
+
+-- Per-channel consent; a missing row means the user has not opted out of
+-- the channel. opted_in on users is the global switch.
+CREATE TABLE IF NOT EXISTS user_channel_consent (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL,
+    opted_in BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, channel)
+);
+
+-- Notification preferences. A channel without a row in
+-- user_category_preferences is enabled for that category.
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    preferred_channel VARCHAR(20),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_category_preferences (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    category VARCHAR(50) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, category, channel)
+);